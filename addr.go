@@ -0,0 +1,147 @@
+package gonso
+
+import (
+	"fmt"
+	"net/netip"
+
+	"golang.org/x/sys/unix"
+)
+
+// Addr is an IP address assigned to a network interface.
+type Addr struct {
+	// Link is the name of the interface the address is assigned to.
+	Link string
+	// Prefix is the address along with the length of its subnet, e.g. 10.0.0.2/24.
+	Prefix netip.Prefix
+}
+
+// AddrAdd assigns `prefix` (e.g. 10.0.0.2/24 or fd00::2/64) to the interface
+// `link` in the set's network namespace.
+// The set must contain a network namespace.
+func (s Set) AddrAdd(link string, prefix netip.Prefix) error {
+	n, err := s.Netlink()
+	if err != nil {
+		return err
+	}
+	defer n.Close()
+	return n.AddrAdd(link, prefix)
+}
+
+// AddrDel removes `prefix` from the interface `link` in the set's network namespace.
+// The set must contain a network namespace.
+func (s Set) AddrDel(link string, prefix netip.Prefix) error {
+	n, err := s.Netlink()
+	if err != nil {
+		return err
+	}
+	defer n.Close()
+	return n.AddrDel(link, prefix)
+}
+
+// AddrAdd assigns `prefix` to the interface `link`.
+// See `Set.AddrAdd`.
+func (n *Netlink) AddrAdd(link string, prefix netip.Prefix) error {
+	return n.addrChange(unix.RTM_NEWADDR, unix.NLM_F_CREATE|unix.NLM_F_EXCL, link, prefix)
+}
+
+// AddrDel removes `prefix` from the interface `link`.
+func (n *Netlink) AddrDel(link string, prefix netip.Prefix) error {
+	return n.addrChange(unix.RTM_DELADDR, 0, link, prefix)
+}
+
+func (n *Netlink) addrChange(typ, flags uint16, link string, prefix netip.Prefix) error {
+	if !prefix.IsValid() {
+		return fmt.Errorf("invalid address %s: %w", prefix, unix.EINVAL)
+	}
+	addr := prefix.Addr().Unmap()
+
+	c := n.c
+	index, err := c.linkIndex(link)
+	if err != nil {
+		return err
+	}
+
+	var m netlinkMsg
+	netlinkHeader(&m, &unix.IfAddrmsg{
+		Family:    addrFamily(addr),
+		Prefixlen: uint8(prefix.Bits()),
+		Index:     uint32(index),
+	})
+	m.attr(unix.IFA_LOCAL, addr.AsSlice())
+	m.attr(unix.IFA_ADDRESS, addr.AsSlice())
+
+	if _, err := c.execute(typ, flags, m.b); err != nil {
+		op := "adding"
+		if typ == unix.RTM_DELADDR {
+			op = "deleting"
+		}
+		return fmt.Errorf("error %s address %s on %s: %w", op, prefix, link, err)
+	}
+	return nil
+}
+
+// AddrList returns the IPv4 and IPv6 addresses assigned to the interface
+// `link` in the set's network namespace. If `link` is empty the addresses of
+// all interfaces are returned.
+// The set must contain a network namespace.
+func (s Set) AddrList(link string) ([]Addr, error) {
+	n, err := s.Netlink()
+	if err != nil {
+		return nil, err
+	}
+	defer n.Close()
+	return n.AddrList(link)
+}
+
+// AddrList returns the IPv4 and IPv6 addresses assigned to the interface
+// `link`, or to all interfaces if `link` is empty.
+func (n *Netlink) AddrList(link string) ([]Addr, error) {
+	c := n.c
+	names, err := c.linkNames()
+	if err != nil {
+		return nil, err
+	}
+
+	var m netlinkMsg
+	netlinkHeader(&m, &unix.IfAddrmsg{Family: unix.AF_UNSPEC})
+	msgs, err := c.execute(unix.RTM_GETADDR, unix.NLM_F_DUMP, m.b)
+	if err != nil {
+		return nil, fmt.Errorf("error listing addresses: %w", err)
+	}
+
+	var addrs []Addr
+	for _, msg := range msgs {
+		if msg.Header.Type != unix.RTM_NEWADDR {
+			continue
+		}
+		info, rest, err := parseHeader[unix.IfAddrmsg](msg.Data)
+		if err != nil {
+			return nil, err
+		}
+		name := names[int(info.Index)]
+		if link != "" && name != link {
+			continue
+		}
+
+		attrs := parseAttrs(rest)
+		// For point-to-point links IFA_ADDRESS is the peer, IFA_LOCAL is always the local address when present.
+		b, ok := attrs[unix.IFA_LOCAL]
+		if !ok {
+			b = attrs[unix.IFA_ADDRESS]
+		}
+		addr, ok := netip.AddrFromSlice(b)
+		if !ok {
+			continue
+		}
+		addrs = append(addrs, Addr{Link: name, Prefix: netip.PrefixFrom(addr, int(info.Prefixlen))})
+	}
+	return addrs, nil
+}
+
+// addrFamily returns the address family (AF_INET or AF_INET6) of `addr`.
+func addrFamily(addr netip.Addr) uint8 {
+	if addr.Is4() {
+		return unix.AF_INET
+	}
+	return unix.AF_INET6
+}
@@ -0,0 +1,84 @@
+package gonso
+
+import (
+	"errors"
+	"net/netip"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestAddr(t *testing.T) {
+	set, err := Unshare(NS_NET)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer set.Close()
+
+	if err := Veth(set, set, "veth0", "veth1", VethOpts{Up: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	prefixes := []netip.Prefix{
+		netip.MustParsePrefix("10.1.0.1/24"),
+		netip.MustParsePrefix("fd00::1/64"),
+	}
+	for _, p := range prefixes {
+		if err := set.AddrAdd("veth0", p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := set.AddrAdd("veth0", prefixes[0]); !errors.Is(err, unix.EEXIST) {
+		t.Errorf("expected EEXIST adding the same address twice, got: %v", err)
+	}
+	if err := set.AddrAdd("doesnotexist0", prefixes[0]); !errors.Is(err, unix.ENODEV) {
+		t.Errorf("expected ENODEV, got: %v", err)
+	}
+
+	hasAddr := func(addrs []Addr, link string, p netip.Prefix) bool {
+		for _, a := range addrs {
+			if a.Link == link && a.Prefix == p {
+				return true
+			}
+		}
+		return false
+	}
+
+	addrs, err := set.AddrList("veth0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range prefixes {
+		if !hasAddr(addrs, "veth0", p) {
+			t.Errorf("expected %s on veth0, got: %v", p, addrs)
+		}
+	}
+	for _, a := range addrs {
+		if a.Link != "veth0" {
+			t.Errorf("expected only addresses of veth0, got: %v", a)
+		}
+	}
+
+	all, err := set.AddrList("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !hasAddr(all, "veth0", prefixes[0]) {
+		t.Errorf("expected %s in all addresses, got: %v", prefixes[0], all)
+	}
+
+	for _, p := range prefixes {
+		if err := set.AddrDel("veth0", p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	addrs, err = set.AddrList("veth0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range prefixes {
+		if hasAddr(addrs, "veth0", p) {
+			t.Errorf("expected %s to be removed, got: %v", p, addrs)
+		}
+	}
+}
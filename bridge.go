@@ -0,0 +1,353 @@
+package gonso
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// ErrAddressesExhausted is returned when a `Bridge` has no free addresses left in its subnet.
+var ErrAddressesExhausted = errors.New("no free addresses")
+
+// BridgeOpts configures a `Bridge`.
+type BridgeOpts struct {
+	// Name is the name of the bridge interface, e.g. "gonso0".
+	Name string
+	// Subnet is the subnet addresses are allocated from, e.g. 10.88.0.0/16.
+	// The first address of the subnet is assigned to the bridge and used as
+	// the default gateway of attached sets.
+	Subnet netip.Prefix
+	// StatePath is the file address allocations are recorded in.
+	// Bridges in different processes can share a subnet as long as they use the same state file.
+	StatePath string
+	// MTU, when non-zero, is set on the bridge and the veth pairs attached to it.
+	MTU int
+}
+
+// Bridge connects many sets to a Linux bridge in a network namespace,
+// e.g. the host's, with each set getting its own address from a subnet.
+//
+// Each attached set gets a veth pair with one end plugged into the bridge and
+// the other end in the set, configured with an address and a default route
+// via the bridge.
+//
+// Address allocations are persisted to a state file which is locked while it
+// is being updated, see `IDAllocator` for the same mechanism for ids.
+// It is safe to use a Bridge from multiple goroutines.
+type Bridge struct {
+	set     Set
+	name    string
+	subnet  netip.Prefix
+	gateway netip.Addr
+	path    string
+	mtu     int
+}
+
+type bridgeState = allocState[bridgeAllocRecord]
+
+type bridgeAllocRecord struct {
+	allocRecord
+	// Link is the name of the attachment's interface on the bridge.
+	Link string `json:"link"`
+	// Pid is the process attaching the set, until the link is on the bridge.
+	Pid  int    `json:"pid,omitempty"`
+	Addr string `json:"addr"`
+}
+
+// NewBridge creates the bridge described by `opts` in the network namespace
+// of `s`, or uses the existing bridge if there is one with the same name.
+// The bridge is set up and the gateway address is assigned to it.
+// The set must contain a network namespace.
+//
+// The set is duplicated so the caller may close `s` once NewBridge returns.
+// The caller is responsible for calling `Close` on the returned Bridge.
+func NewBridge(s Set, opts BridgeOpts) (_ *Bridge, retErr error) {
+	if opts.Name == "" || opts.StatePath == "" {
+		return nil, fmt.Errorf("bridge name and state path are required: %w", unix.EINVAL)
+	}
+	if !opts.Subnet.IsValid() {
+		return nil, fmt.Errorf("invalid bridge subnet %s: %w", opts.Subnet, unix.EINVAL)
+	}
+	subnet := netip.PrefixFrom(opts.Subnet.Addr().Unmap(), opts.Subnet.Bits()).Masked()
+	gateway := subnet.Addr().Next()
+	if !subnet.Contains(gateway.Next()) {
+		return nil, fmt.Errorf("bridge subnet %s is too small: %w", subnet, unix.EINVAL)
+	}
+
+	if _, ok := s.fds[unix.CLONE_NEWNET]; !ok {
+		return nil, fmt.Errorf("flag not in set for %s", nsFlagsReverse[unix.CLONE_NEWNET])
+	}
+	netns, err := s.Dup(unix.CLONE_NEWNET)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if retErr != nil {
+			netns.Close()
+		}
+	}()
+
+	c, err := netns.netlink()
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	var m netlinkMsg
+	netlinkHeader(&m, &unix.IfInfomsg{Family: unix.AF_UNSPEC})
+	m.attrString(unix.IFLA_IFNAME, opts.Name)
+	if opts.MTU > 0 {
+		m.attrU32(unix.IFLA_MTU, uint32(opts.MTU))
+	}
+	m.nest(unix.IFLA_LINKINFO, func() {
+		m.attrString(unix.IFLA_INFO_KIND, "bridge")
+	})
+	if _, err := c.execute(unix.RTM_NEWLINK, unix.NLM_F_CREATE|unix.NLM_F_EXCL, m.b); err != nil {
+		if !errors.Is(err, unix.EEXIST) {
+			return nil, fmt.Errorf("error creating bridge %s: %w", opts.Name, err)
+		}
+		l, err := c.getLink(0, opts.Name)
+		if err != nil {
+			return nil, err
+		}
+		if l.Kind != "bridge" {
+			return nil, fmt.Errorf("link %s already exists and is not a bridge: %w", opts.Name, unix.EEXIST)
+		}
+	}
+
+	if err := c.setLinkFlags(opts.Name, unix.IFF_UP, unix.IFF_UP); err != nil {
+		return nil, fmt.Errorf("error setting bridge %s up: %w", opts.Name, err)
+	}
+	if err := netns.AddrAdd(opts.Name, netip.PrefixFrom(gateway, subnet.Bits())); err != nil && !errors.Is(err, unix.EEXIST) {
+		return nil, err
+	}
+
+	return &Bridge{
+		set:     netns,
+		name:    opts.Name,
+		subnet:  subnet,
+		gateway: gateway,
+		path:    opts.StatePath,
+		mtu:     opts.MTU,
+	}, nil
+}
+
+// Close releases the bridge's reference to its network namespace.
+// The bridge itself and any attachments are left in place.
+func (b *Bridge) Close() error {
+	return b.set.Close()
+}
+
+// Name returns the name of the bridge interface.
+func (b *Bridge) Name() string {
+	return b.name
+}
+
+// Gateway returns the address of the bridge, which is the default gateway of attached sets.
+func (b *Bridge) Gateway() netip.Addr {
+	return b.gateway
+}
+
+// BridgeAttachment is a set's connection to a `Bridge`.
+type BridgeAttachment struct {
+	// ID identifies the address allocation in the bridge's state.
+	ID string
+	// Link is the name of the interface in the attached set.
+	Link string
+	// HostLink is the name of the interface plugged into the bridge.
+	HostLink string
+	// Addr is the address assigned to `Link`.
+	Addr netip.Prefix
+	// Gateway is the default gateway of the attached set.
+	Gateway netip.Addr
+
+	b    *Bridge
+	once sync.Once
+	err  error
+}
+
+// Attach connects the set `s` to the bridge with a veth pair whose end in the
+// set is named `link`. An address is allocated for the set and assigned to
+// `link` along with a default route via the bridge.
+// The set must contain a network namespace.
+//
+// If anything fails the veth pair is removed and the address is released.
+// The caller is responsible for calling `Release` on the returned attachment
+// once the set no longer needs it, see `Bridge.WithAttach` to tie it to the
+// lifetime of a set instead.
+func (b *Bridge) Attach(s Set, link string) (_ *BridgeAttachment, retErr error) {
+	id, err := newAllocationID()
+	if err != nil {
+		return nil, err
+	}
+
+	// Interface names are limited to 15 characters.
+	hostLink := "gs" + id[:12]
+	addr, err := b.allocate(id, hostLink)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &BridgeAttachment{
+		ID:       id,
+		Link:     link,
+		HostLink: hostLink,
+		Addr:     netip.PrefixFrom(addr, b.subnet.Bits()),
+		Gateway:  b.gateway,
+		b:        b,
+	}
+	defer func() {
+		if retErr != nil {
+			a.Release()
+		}
+	}()
+
+	if err := Veth(b.set, s, a.HostLink, link, VethOpts{MTU: b.mtu, Up: true}); err != nil {
+		return nil, err
+	}
+
+	c, err := b.set.netlink()
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	master, err := c.linkIndex(b.name)
+	if err != nil {
+		return nil, err
+	}
+	var m netlinkMsg
+	netlinkHeader(&m, &unix.IfInfomsg{Family: unix.AF_UNSPEC})
+	m.attrString(unix.IFLA_IFNAME, a.HostLink)
+	m.attrU32(unix.IFLA_MASTER, uint32(master))
+	if _, err := c.execute(unix.RTM_NEWLINK, 0, m.b); err != nil {
+		return nil, fmt.Errorf("error attaching %s to bridge %s: %w", a.HostLink, b.name, err)
+	}
+	// From here on the address is in use for as long as the link is on the bridge.
+	err = updateState(b.path, func(state *bridgeState) error {
+		for i, r := range state.Allocations {
+			if r.ID == id {
+				state.Allocations[i].Pid = 0
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.AddrAdd(link, a.Addr); err != nil {
+		return nil, err
+	}
+	if err := s.RouteAdd(Route{Gateway: b.gateway, Link: link}); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Release removes the veth pair and returns the attachment's address to the bridge.
+// It is safe to call Release multiple times.
+func (a *BridgeAttachment) Release() error {
+	a.once.Do(func() {
+		c, err := a.b.set.netlink()
+		if err == nil {
+			// Deleting the end on the bridge deletes the end in the set as well.
+			err = c.deleteLink(a.HostLink)
+			c.Close()
+			if errors.Is(err, unix.ENODEV) {
+				// The set's network namespace is gone, which takes the veth pair with it.
+				err = nil
+			}
+			if err != nil {
+				err = fmt.Errorf("error deleting link %s: %w", a.HostLink, err)
+			}
+		}
+
+		releaseErr := releaseRecord[bridgeAllocRecord](a.b.path, a.ID)
+		if err == nil {
+			err = releaseErr
+		}
+		a.err = err
+	})
+	return a.err
+}
+
+// WithAttach attaches each new set to the bridge with `link` as the name of
+// the interface in the set. See `Bridge.Attach`.
+// It can be used as an UnshareOpt to configure the Unshare function.
+//
+// This is suitable for use with a `Pool`, see `NewPool`. The attachment is
+// released when the resulting `Set` is closed or if creating the set fails.
+func (b *Bridge) WithAttach(link string) UnshareOpt {
+	return func(c *UnshareConfig) {
+		c.afterCreate = append(c.afterCreate, func(s Set) (func() error, error) {
+			a, err := b.Attach(s, link)
+			if err != nil {
+				return nil, err
+			}
+			return a.Release, nil
+		})
+	}
+}
+
+// allocate reserves the first free address in the bridge's subnet for the
+// attachment whose interface on the bridge is `link`.
+//
+// An address stays allocated while its link is on the bridge, or while the
+// process attaching it is still around and has not plugged it in yet.
+func (b *Bridge) allocate(id, link string) (netip.Addr, error) {
+	c, err := b.set.netlink()
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	defer c.Close()
+
+	var addr netip.Addr
+	err = updateState(b.path, func(state *bridgeState) error {
+		links, err := c.linkList()
+		if err != nil {
+			return err
+		}
+		master := -1
+		for _, l := range links {
+			if l.Name == b.name {
+				master = l.Index
+			}
+		}
+		attached := make(map[string]bool, len(links))
+		for _, l := range links {
+			if l.MasterIndex == master {
+				attached[l.Name] = true
+			}
+		}
+		state.prune(func(r bridgeAllocRecord) bool {
+			return attached[r.Link] || (r.Pid != 0 && processExists(r.Pid))
+		})
+
+		used := make(map[netip.Addr]bool, len(state.Allocations))
+		for _, r := range state.Allocations {
+			if a, err := netip.ParseAddr(r.Addr); err == nil {
+				used[a] = true
+			}
+		}
+
+		for a := b.gateway.Next(); b.subnet.Contains(a); a = a.Next() {
+			if used[a] {
+				continue
+			}
+			if a.Is4() && !b.subnet.Contains(a.Next()) {
+				// The last address is the broadcast address.
+				break
+			}
+			addr = a
+			state.Allocations = append(state.Allocations, bridgeAllocRecord{allocRecord: allocRecord{ID: id}, Link: link, Pid: os.Getpid(), Addr: a.String()})
+			return nil
+		}
+		return fmt.Errorf("error allocating address from %s: %w", b.subnet, ErrAddressesExhausted)
+	})
+	return addr, err
+}
@@ -0,0 +1,317 @@
+package gonso
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBridge(t *testing.T) {
+	host, err := Unshare(NS_NET, WithLoopback())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer host.Close()
+
+	statePath := filepath.Join(t.TempDir(), "bridge.json")
+	opts := BridgeOpts{
+		Name:      "gonso0",
+		Subnet:    netip.MustParsePrefix("10.88.0.0/29"),
+		StatePath: statePath,
+		MTU:       1400,
+	}
+	b, err := NewBridge(host, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	// Creating the bridge again reuses the existing one.
+	b2, err := NewBridge(host, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b2.Close()
+	if b2.Gateway() != netip.MustParseAddr("10.88.0.1") {
+		t.Errorf("unexpected gateway: %s", b2.Gateway())
+	}
+
+	sets, err := UnshareN(NS_NET, 2, b.WithAttach("eth0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addrOf := func(s Set) netip.Addr {
+		t.Helper()
+		addrs, err := s.AddrList("eth0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, a := range addrs {
+			if a.Prefix.Addr().Is4() {
+				return a.Prefix.Addr()
+			}
+		}
+		t.Fatalf("expected an IPv4 address, got: %v", addrs)
+		return netip.Addr{}
+	}
+
+	addrA, addrB := addrOf(sets[0]), addrOf(sets[1])
+	if addrA == addrB {
+		t.Fatalf("expected distinct addresses, got %s twice", addrA)
+	}
+	for _, a := range []netip.Addr{addrA, addrB} {
+		if !opts.Subnet.Contains(a) || a == b.Gateway() {
+			t.Errorf("unexpected address %s", a)
+		}
+	}
+
+	routes, err := sets[0].RouteList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var hasDefault bool
+	for _, r := range routes {
+		if r.Dst.Bits() == 0 && r.Gateway == b.Gateway() && r.Link == "eth0" {
+			hasDefault = true
+		}
+	}
+	if !hasDefault {
+		t.Errorf("expected default route via the bridge, got: %+v", routes)
+	}
+
+	// A second bridge sharing the state file can't allocate the same addresses.
+	// The /29 has 5 addresses for sets, so there are 3 left.
+	var extra []*BridgeAttachment
+	for i := 0; i < 3; i++ {
+		s, err := Unshare(NS_NET)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer s.Close()
+		a, err := b2.Attach(s, "eth0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if a.Addr.Addr() == addrA || a.Addr.Addr() == addrB {
+			t.Errorf("address %s allocated twice", a.Addr)
+		}
+		extra = append(extra, a)
+	}
+	s, err := Unshare(NS_NET)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if _, err := b.Attach(s, "eth0"); !errors.Is(err, ErrAddressesExhausted) {
+		t.Errorf("expected addresses to be exhausted, got: %v", err)
+	}
+	if links := bridgeHostLinks(t, host); len(links) != 5 {
+		t.Errorf("expected failed attach to be cleaned up, got: %v", links)
+	}
+	for _, a := range extra {
+		if err := a.Release(); err != nil {
+			t.Fatal(err)
+		}
+		if err := a.Release(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Addresses are kept while their link is on the bridge, even once the
+	// process which attached it is gone, and reclaimed when the link isn't.
+	err = updateState(statePath, func(state *bridgeState) error {
+		for i := range state.Allocations {
+			state.Allocations[i].Pid = 1 << 30
+		}
+		for a := b.Gateway().Next(); opts.Subnet.Contains(a.Next()); a = a.Next() {
+			if a == addrA || a == addrB {
+				continue
+			}
+			id := "stale" + a.String()
+			state.Allocations = append(state.Allocations, bridgeAllocRecord{allocRecord: allocRecord{ID: id}, Link: "gs" + id[:8], Pid: 1 << 30, Addr: a.String()})
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := b.Attach(s, "eth0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Addr.Addr() == addrA || a.Addr.Addr() == addrB {
+		t.Errorf("address %s of an attached set was reclaimed", a.Addr)
+	}
+	if err := a.Release(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Connect from one set to the other through the bridge.
+	netA, err := sets[0].Dup(NS_NET)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer netA.Close()
+	netB, err := sets[1].Dup(NS_NET)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer netB.Close()
+
+	var l net.Listener
+	var listenErr error
+	if err := netA.Do(func() { l, listenErr = net.Listen("tcp", netip.AddrPortFrom(addrA, 0).String()) }); err != nil {
+		t.Fatal(err)
+	}
+	if listenErr != nil {
+		t.Fatal(listenErr)
+	}
+	defer l.Close()
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		conn.Write([]byte("hello"))
+		conn.Close()
+	}()
+
+	var conn net.Conn
+	var dialErr error
+	if err := netB.Do(func() { conn, dialErr = net.Dial("tcp", l.Addr().String()) }); err != nil {
+		t.Fatal(err)
+	}
+	if dialErr != nil {
+		t.Fatal(dialErr)
+	}
+	data, err := io.ReadAll(conn)
+	conn.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "hello" {
+		t.Errorf("unexpected data: %q", string(data))
+	}
+
+	// Closing the sets releases the attachments.
+	for _, s := range sets {
+		if err := s.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if links := bridgeHostLinks(t, host); len(links) != 0 {
+		t.Errorf("expected host links to be removed, got: %v", links)
+	}
+	err = updateState(statePath, func(state *bridgeState) error {
+		if len(state.Allocations) != 0 {
+			t.Errorf("expected all addresses to be released, got: %+v", state.Allocations)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBridgePool(t *testing.T) {
+	host, err := Unshare(NS_NET, WithLoopback())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer host.Close()
+
+	statePath := filepath.Join(t.TempDir(), "bridge.json")
+	b, err := NewBridge(host, BridgeOpts{
+		Name:      "gonso0",
+		Subnet:    netip.MustParsePrefix("10.88.0.0/24"),
+		StatePath: statePath,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	countAllocs := func() int {
+		var n int
+		updateState(statePath, func(state *bridgeState) error {
+			n = len(state.Allocations)
+			return nil
+		})
+		return n
+	}
+
+	p := NewPool(NS_NET, nil, b.WithAttach("eth0"))
+
+	s, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	links, err := s.LinkList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found bool
+	for _, l := range links {
+		if l.Name == "eth0" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("expected set from pool to be attached, got: %+v", links)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n := countAllocs(); n != 0 {
+		t.Fatalf("expected address to be released, got %d allocations", n)
+	}
+
+	p.notify = func() {
+		p.cvar.Signal()
+	}
+
+	ctx, cancelP := p.Run(context.Background(), 3)
+	defer cancelP()
+
+	ctxT, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	waitForPool(t, ctxT, p, 3)
+
+	if n := countAllocs(); n != 3 {
+		t.Fatalf("expected 3 allocations, got %d", n)
+	}
+	if links := bridgeHostLinks(t, host); len(links) != 3 {
+		t.Errorf("expected 3 host links, got: %v", links)
+	}
+
+	p.drain()
+	if n := countAllocs(); n != 0 {
+		t.Fatalf("expected addresses to be released, got %d allocations", n)
+	}
+	if links := bridgeHostLinks(t, host); len(links) != 0 {
+		t.Errorf("expected host links to be removed, got: %v", links)
+	}
+}
+
+func bridgeHostLinks(t *testing.T, s Set) []string {
+	t.Helper()
+	links, err := s.LinkList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, l := range links {
+		if strings.HasPrefix(l.Name, "gs") {
+			names = append(names, l.Name)
+		}
+	}
+	return names
+}
@@ -0,0 +1,288 @@
+package gonso
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// CopyIn copies the file or directory tree at `hostPath` in the caller's
+// mount namespace to `nsPath` in the set's mount namespace.
+// `nsPath` is the path of the copy itself rather than a directory to copy into,
+// existing files are overwritten.
+//
+// See `Set.CopyOut` for what is preserved.
+func (s Set) CopyIn(hostPath, nsPath string) error {
+	r, err := s.RootFS()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	owner, err := s.copyOwner(MapID)
+	if err != nil {
+		return err
+	}
+	return copyTree(hostFS{}, hostPath, r, rootFSName(nsPath), owner)
+}
+
+// CopyOut copies the file or directory tree at `nsPath` in the set's mount
+// namespace to `hostPath` in the caller's mount namespace.
+// `hostPath` is the path of the copy itself rather than a directory to copy into,
+// existing files are overwritten.
+//
+// File modes, ownership, symlinks and extended attributes of regular files
+// and directories are preserved. Hard links are copied as separate files.
+// File contents are streamed rather than read into memory.
+//
+// If the set contains a user namespace, ownership is translated so that
+// files are owned by the same ids in the set as they are on the host,
+// e.g. a file owned by root in the set is owned by root on the host.
+// An error wrapping `ErrUnmappedID` is returned if an owner cannot be translated.
+//
+// Paths in the set's mount namespace are always resolved inside it, see `Set.RootFS`.
+func (s Set) CopyOut(nsPath, hostPath string) error {
+	r, err := s.RootFS()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	owner, err := s.copyOwner(UnmapID)
+	if err != nil {
+		return err
+	}
+	return copyTree(r, rootFSName(nsPath), hostFS{}, hostPath, owner)
+}
+
+// copyOwner returns a function translating the owner of a file with `translate`
+// using the id mappings of the set's user namespace, if any.
+func (s Set) copyOwner(translate func([]IDMap, int) (int, error)) (func(uid, gid int) (int, int, error), error) {
+	if _, ok := s.fds[unix.CLONE_NEWUSER]; !ok {
+		return func(uid, gid int) (int, int, error) { return uid, gid, nil }, nil
+	}
+
+	uidMaps, gidMaps, err := s.IDMaps()
+	if err != nil {
+		return nil, err
+	}
+	return func(uid, gid int) (int, int, error) {
+		newUID, err := translate(uidMaps, uid)
+		if err != nil {
+			return 0, 0, fmt.Errorf("uid %d: %w", uid, err)
+		}
+		newGID, err := translate(gidMaps, gid)
+		if err != nil {
+			return 0, 0, fmt.Errorf("gid %d: %w", gid, err)
+		}
+		return newUID, newGID, nil
+	}, nil
+}
+
+// rootFSName converts a path in a mount namespace to a name for use with `RootFS`.
+func rootFSName(p string) string {
+	p = strings.TrimPrefix(path.Clean("/"+p), "/")
+	if p == "" {
+		return "."
+	}
+	return p
+}
+
+// copyFS is the set of operations needed to copy files to or from a filesystem.
+type copyFS interface {
+	Lstat(name string) (fs.FileInfo, error)
+	ReadLink(name string) (string, error)
+	ReadDir(name string) ([]fs.DirEntry, error)
+	OpenFile(name string, flag int, perm fs.FileMode) (*os.File, error)
+	Mkdir(name string, perm fs.FileMode) error
+	Symlink(oldname, newname string) error
+	Remove(name string) error
+	Lchown(name string, uid, gid int) error
+}
+
+// hostFS is a copyFS for the caller's mount namespace.
+type hostFS struct{}
+
+func (hostFS) Lstat(name string) (fs.FileInfo, error)     { return os.Lstat(name) }
+func (hostFS) ReadLink(name string) (string, error)       { return os.Readlink(name) }
+func (hostFS) ReadDir(name string) ([]fs.DirEntry, error) { return os.ReadDir(name) }
+func (hostFS) Mkdir(name string, perm fs.FileMode) error  { return os.Mkdir(name, perm) }
+func (hostFS) Symlink(oldname, newname string) error      { return os.Symlink(oldname, newname) }
+func (hostFS) Remove(name string) error                   { return os.Remove(name) }
+func (hostFS) Lchown(name string, uid, gid int) error     { return os.Lchown(name, uid, gid) }
+func (hostFS) OpenFile(name string, flag int, perm fs.FileMode) (*os.File, error) {
+	return os.OpenFile(name, flag, perm)
+}
+
+func copyTree(src copyFS, srcName string, dst copyFS, dstName string, owner func(uid, gid int) (int, int, error)) error {
+	fi, err := src.Lstat(srcName)
+	if err != nil {
+		return err
+	}
+	st := fi.Sys().(*syscall.Stat_t)
+	uid, gid, err := owner(int(st.Uid), int(st.Gid))
+	if err != nil {
+		return fmt.Errorf("error translating owner of %s: %w", srcName, err)
+	}
+
+	switch fi.Mode().Type() {
+	case fs.ModeSymlink:
+		target, err := src.ReadLink(srcName)
+		if err != nil {
+			return err
+		}
+		err = dst.Symlink(target, dstName)
+		if errors.Is(err, fs.ErrExist) {
+			if err := dst.Remove(dstName); err != nil {
+				return err
+			}
+			err = dst.Symlink(target, dstName)
+		}
+		if err != nil {
+			return err
+		}
+		return dst.Lchown(dstName, uid, gid)
+	case fs.ModeDir:
+		if err := dst.Mkdir(dstName, 0o700); err != nil {
+			if !errors.Is(err, fs.ErrExist) {
+				return err
+			}
+			if dfi, err := dst.Lstat(dstName); err != nil || !dfi.IsDir() {
+				return &fs.PathError{Op: "mkdir", Path: dstName, Err: unix.ENOTDIR}
+			}
+		}
+
+		entries, err := src.ReadDir(srcName)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if err := copyTree(src, path.Join(srcName, e.Name()), dst, path.Join(dstName, e.Name()), owner); err != nil {
+				return err
+			}
+		}
+
+		// Metadata is set last so a read-only directory can still be populated.
+		return copyMetadata(src, srcName, dst, dstName, unix.O_RDONLY|unix.O_DIRECTORY, fi.Mode(), uid, gid, nil)
+	case 0:
+		return copyMetadata(src, srcName, dst, dstName, unix.O_WRONLY|unix.O_CREAT|unix.O_TRUNC, fi.Mode(), uid, gid, func(srcF, dstF *os.File) error {
+			_, err := io.Copy(dstF, srcF)
+			return err
+		})
+	default:
+		return &fs.PathError{Op: "copy", Path: srcName, Err: fmt.Errorf("unsupported file type %v: %w", fi.Mode().Type(), unix.ENOTSUP)}
+	}
+}
+
+// copyMetadata opens both files and copies the data (if `copyData` is not nil), ownership, mode and xattrs.
+func copyMetadata(src copyFS, srcName string, dst copyFS, dstName string, dstFlags int, mode fs.FileMode, uid, gid int, copyData func(srcF, dstF *os.File) error) error {
+	srcF, err := src.OpenFile(srcName, unix.O_RDONLY|unix.O_NOFOLLOW, 0)
+	if err != nil {
+		return err
+	}
+	defer srcF.Close()
+
+	dstF, err := dst.OpenFile(dstName, dstFlags|unix.O_NOFOLLOW, 0o600)
+	if err != nil {
+		return err
+	}
+	defer dstF.Close()
+
+	if copyData != nil {
+		if err := copyData(srcF, dstF); err != nil {
+			return fmt.Errorf("error copying %s: %w", srcName, err)
+		}
+	}
+
+	// chown may clear setuid/setgid bits so it must happen before chmod.
+	if err := dstF.Chown(uid, gid); err != nil {
+		return err
+	}
+	if err := dstF.Chmod(mode); err != nil {
+		return err
+	}
+	if err := copyXattrs(srcF, dstF); err != nil {
+		return fmt.Errorf("error copying xattrs from %s: %w", srcName, err)
+	}
+	return dstF.Close()
+}
+
+func copyXattrs(src, dst *os.File) error {
+	srcFd, dstFd := int(src.Fd()), int(dst.Fd())
+
+	names, err := listXattrs(srcFd)
+	if err != nil {
+		if err == unix.ENOTSUP {
+			return nil
+		}
+		return err
+	}
+
+	for _, name := range names {
+		value, err := getXattr(srcFd, name)
+		if err != nil {
+			if err == unix.ENODATA {
+				continue
+			}
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		if err := unix.Fsetxattr(dstFd, name, value, 0); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func listXattrs(fd int) ([]string, error) {
+	for {
+		size, err := unix.Flistxattr(fd, nil)
+		if err != nil {
+			return nil, err
+		}
+		if size == 0 {
+			return nil, nil
+		}
+		buf := make([]byte, size)
+		n, err := unix.Flistxattr(fd, buf)
+		if err == unix.ERANGE {
+			// The list grew in the meantime.
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var names []string
+		for _, name := range strings.Split(string(buf[:n]), "\x00") {
+			if name != "" {
+				names = append(names, name)
+			}
+		}
+		return names, nil
+	}
+}
+
+func getXattr(fd int, name string) ([]byte, error) {
+	for {
+		size, err := unix.Fgetxattr(fd, name, nil)
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size)
+		n, err := unix.Fgetxattr(fd, name, buf)
+		if err == unix.ERANGE {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	}
+}
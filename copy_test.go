@@ -0,0 +1,194 @@
+package gonso
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestCopy(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "src")
+	if err := os.Mkdir(src, 0o750); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "file"), []byte("hello"), 0o640); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chown(filepath.Join(src, "file"), 1000, 1001); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("file", filepath.Join(src, "link")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(src, "ro"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "ro", "nested"), []byte("nested"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(filepath.Join(src, "ro", "nested"), 0o755|fs.ModeSetuid); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(filepath.Join(src, "ro"), 0o555); err != nil {
+		t.Fatal(err)
+	}
+
+	hasXattrs := unix.Setxattr(filepath.Join(src, "file"), "user.gonso", []byte("test"), 0) == nil
+
+	type entry struct {
+		mode     fs.FileMode
+		uid, gid uint32
+		content  string
+	}
+	expected := map[string]entry{
+		".":         {mode: fs.ModeDir | 0o750},
+		"file":      {mode: 0o640, uid: 1000, gid: 1001, content: "hello"},
+		"link":      {mode: fs.ModeSymlink | 0o777, content: "file"},
+		"ro":        {mode: fs.ModeDir | 0o555},
+		"ro/nested": {mode: fs.ModeSetuid | 0o755, content: "nested"},
+	}
+
+	check := func(t *testing.T, fsys interface {
+		Lstat(string) (fs.FileInfo, error)
+		ReadLink(string) (string, error)
+		ReadFile(string) ([]byte, error)
+	}, root string, idOffset uint32) {
+		t.Helper()
+
+		for name, e := range expected {
+			p := filepath.Join(root, name)
+			fi, err := fsys.Lstat(p)
+			if err != nil {
+				t.Error(err)
+				continue
+			}
+			if fi.Mode() != e.mode {
+				t.Errorf("%s: expected mode %v, got %v", name, e.mode, fi.Mode())
+			}
+			st := fi.Sys().(*syscall.Stat_t)
+			if st.Uid != e.uid+idOffset || st.Gid != e.gid+idOffset {
+				t.Errorf("%s: expected owner %d:%d, got %d:%d", name, e.uid+idOffset, e.gid+idOffset, st.Uid, st.Gid)
+			}
+
+			switch {
+			case fi.Mode()&fs.ModeSymlink != 0:
+				target, err := fsys.ReadLink(p)
+				if err != nil {
+					t.Error(err)
+				} else if target != e.content {
+					t.Errorf("%s: expected symlink to %q, got %q", name, e.content, target)
+				}
+			case fi.Mode().IsRegular():
+				data, err := fsys.ReadFile(p)
+				if err != nil {
+					t.Error(err)
+				} else if string(data) != e.content {
+					t.Errorf("%s: expected content %q, got %q", name, e.content, string(data))
+				}
+			}
+		}
+	}
+
+	for name, flags := range map[string]int{"mnt": NS_MNT, "userns": NS_MNT | NS_USER} {
+		flags := flags
+		t.Run(name, func(t *testing.T) {
+			var (
+				opts     []UnshareOpt
+				idOffset uint32
+			)
+			if flags&NS_USER != 0 {
+				maps := []IDMap{{ContainerID: 0, HostID: 100000, Size: 65536}}
+				opts = append(opts, WithIDMaps(maps, maps))
+				idOffset = 100000
+			}
+
+			set, err := Unshare(flags, append(opts, WithMountPropagation(unix.MS_PRIVATE, true))...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer set.Close()
+
+			// Copy into a tmpfs that only exists in the set to make sure the
+			// copy really happens inside of the mount namespace.
+			target := t.TempDir()
+			m, err := NewFSMount("tmpfs", FSMountOpts{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer m.Close()
+			if err := set.MoveMount(m, target); err != nil {
+				t.Fatal(err)
+			}
+
+			nsPath := filepath.Join(target, "copy")
+			if err := set.CopyIn(src, nsPath); err != nil {
+				t.Fatal(err)
+			}
+			// Copying again should overwrite the existing files.
+			if err := set.CopyIn(src, nsPath); err != nil {
+				t.Fatal(err)
+			}
+
+			rootfs, err := set.RootFS()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer rootfs.Close()
+			check(t, nsFS{rootfs}, nsPath, idOffset)
+
+			if hasXattrs {
+				f, err := rootfs.OpenFile(rootFSName(filepath.Join(nsPath, "file")), os.O_RDONLY, 0)
+				if err != nil {
+					t.Fatal(err)
+				}
+				buf := make([]byte, 16)
+				n, err := unix.Fgetxattr(int(f.Fd()), "user.gonso", buf)
+				f.Close()
+				if err != nil || string(buf[:n]) != "test" {
+					t.Errorf("expected xattr to be copied, got %q: %v", string(buf[:n]), err)
+				}
+			}
+
+			out := filepath.Join(t.TempDir(), "out")
+			if err := set.CopyOut(nsPath, out); err != nil {
+				t.Fatal(err)
+			}
+			check(t, hostCheckFS{}, out, 0)
+
+			if _, err := os.Stat(nsPath); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("expected copy to only be visible in the set: %v", err)
+			}
+		})
+	}
+
+	t.Run("unmapped owner", func(t *testing.T) {
+		maps := []IDMap{{ContainerID: 0, HostID: 100000, Size: 10}}
+		set, err := Unshare(NS_MNT|NS_USER, WithIDMaps(maps, maps))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer set.Close()
+
+		if err := set.CopyIn(src, filepath.Join(t.TempDir(), "copy")); !errors.Is(err, ErrUnmappedID) {
+			t.Errorf("expected unmapped id error, got: %v", err)
+		}
+	})
+}
+
+// nsFS adapts a RootFS to take absolute paths.
+type nsFS struct{ r *RootFS }
+
+func (f nsFS) Lstat(p string) (fs.FileInfo, error) { return f.r.Lstat(rootFSName(p)) }
+func (f nsFS) ReadLink(p string) (string, error)   { return f.r.ReadLink(rootFSName(p)) }
+func (f nsFS) ReadFile(p string) ([]byte, error)   { return f.r.ReadFile(rootFSName(p)) }
+
+type hostCheckFS struct{}
+
+func (hostCheckFS) Lstat(p string) (fs.FileInfo, error) { return os.Lstat(p) }
+func (hostCheckFS) ReadLink(p string) (string, error)   { return os.Readlink(p) }
+func (hostCheckFS) ReadFile(p string) ([]byte, error)   { return os.ReadFile(p) }
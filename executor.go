@@ -0,0 +1,259 @@
+package gonso
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// ErrExecutorClosed is returned when trying to use an `Executor` that has been closed.
+var ErrExecutorClosed = errors.New("executor is closed")
+
+// Executor is a locked OS thread which already lives in a freshly unshared set of namespaces.
+// Functions passed to `Do` run directly on that thread, so there is no setns cost per call.
+//
+// Create one with `NewExecutor` or get one from an `ExecutorPool`.
+// The thread exits when the executor is closed, it is never returned to Go's thread pool.
+type Executor struct {
+	set  Set
+	work chan func()
+	quit chan struct{}
+	done chan struct{}
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// NewExecutor creates a new locked OS thread with the namespaces in `flags` unshared.
+//
+// `afterCreate` is called on the new thread once the namespaces are unshared.
+//
+// CLONE_NEWUSER cannot be unshared from a multi-threaded process so it is not supported here.
+func NewExecutor(flags int, afterCreate func() error) (*Executor, error) {
+	if flags&unix.CLONE_NEWUSER != 0 {
+		return nil, fmt.Errorf("executors cannot unshare a user namespace: %w", unix.EINVAL)
+	}
+
+	e := &Executor{
+		work: make(chan func()),
+		quit: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+
+	chErr := make(chan error, 1)
+	go func() {
+		runtime.LockOSThread()
+		// This thread is never unlocked, when this goroutine returns Go will exit the thread.
+		defer close(e.done)
+
+		err := func() error {
+			if err := unshare(flags); err != nil {
+				return fmt.Errorf("error unsharing namespaces: %w", err)
+			}
+
+			s, err := curNamespaces(flags)
+			if err != nil {
+				return fmt.Errorf("error getting namespaces: %w", err)
+			}
+
+			if afterCreate != nil {
+				if err := afterCreate(); err != nil {
+					s.Close()
+					return fmt.Errorf("error running after create function: %w", err)
+				}
+			}
+
+			e.set = s
+			return nil
+		}()
+		chErr <- err
+		if err != nil {
+			return
+		}
+
+		for {
+			select {
+			case f := <-e.work:
+				f()
+			case <-e.quit:
+				return
+			}
+		}
+	}()
+
+	if err := <-chErr; err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Do runs the given function on the executor's thread and waits for it to return.
+//
+// The function should not create any new goroutines since those goroutines
+// will not be in the executor's namespaces. Calls to `Do` are serialized.
+//
+// The function must not call `Do` on the same executor, the thread is busy
+// running the outer function so the inner call would never be picked up.
+func (e *Executor) Do(f func()) error {
+	chDone := make(chan struct{})
+	work := func() {
+		defer close(chDone)
+		f()
+	}
+
+	e.mu.Lock()
+	closed := e.closed
+	e.mu.Unlock()
+	if closed {
+		return ErrExecutorClosed
+	}
+
+	select {
+	case e.work <- work:
+	case <-e.done:
+		return ErrExecutorClosed
+	}
+
+	<-chDone
+	return nil
+}
+
+// Set returns a new `Set` for the executor's namespaces.
+// The caller is responsible for closing the returned set.
+func (e *Executor) Set() (Set, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.closed {
+		return Set{}, ErrExecutorClosed
+	}
+	return e.set.Dup(0)
+}
+
+func (e *Executor) isClosed() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.closed
+}
+
+// Close stops the executor and lets its thread exit.
+func (e *Executor) Close() error {
+	e.mu.Lock()
+	if e.closed {
+		e.mu.Unlock()
+		return nil
+	}
+	e.closed = true
+	close(e.quit)
+	e.mu.Unlock()
+
+	<-e.done
+	return e.set.Close()
+}
+
+// ExecutorPool manages a pool of Executors.
+// It is safe to use an ExecutorPool from multiple goroutines.
+// Create one using `NewExecutorPool`.
+//
+// This is the same as `Pool` except that it holds live threads rather than namespace file descriptors.
+type ExecutorPool struct {
+	filler[*Executor]
+	flags int
+
+	afterCreate func() error
+}
+
+// NewExecutorPool creates a new executor pool with the given flags.
+// Call `pool.Run` to start filling the pool.
+//
+// `afterCreate` is called on the executor's thread after it is created.
+func NewExecutorPool(flags int, afterCreate func() error) *ExecutorPool {
+	p := &ExecutorPool{
+		flags:       flags,
+		afterCreate: afterCreate,
+	}
+	p.filler.init(p.getN, func(e *Executor) { e.Close() })
+	return p
+}
+
+func (p *ExecutorPool) get() (*Executor, error) {
+	return NewExecutor(p.flags, p.afterCreate)
+}
+
+// getN creates `n` executors.
+func (p *ExecutorPool) getN(ctx context.Context, n int) ([]*Executor, error) {
+	execs := make([]*Executor, 0, n)
+	for i := 0; i < n; i++ {
+		err := ctx.Err()
+		var e *Executor
+		if err == nil {
+			e, err = p.get()
+		}
+		if err != nil {
+			for _, e := range execs {
+				e.Close()
+			}
+			return nil, err
+		}
+		execs = append(execs, e)
+	}
+	return execs, nil
+}
+
+// Get returns an executor from the pool.
+// If there are no executors available, Get will create a new one.
+func (p *ExecutorPool) Get() (*Executor, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for {
+		e, ok := p.take()
+		if !ok {
+			return p.get()
+		}
+		if !e.isClosed() {
+			return e, nil
+		}
+	}
+}
+
+// Put returns an executor to the pool so its thread can be recycled.
+// It is up to the caller to ensure the executor's namespaces are in a re-usable state.
+//
+// Executors are only kept while the pool is running (see `Run`), otherwise
+// nothing would ever close them, so the executor is closed instead.
+// Executors which are already closed are dropped.
+//
+// If the executor is not going to be re-used, call `Executor.Close` instead.
+func (p *ExecutorPool) Put(e *Executor) {
+	if e.isClosed() {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.running {
+		e.Close()
+		return
+	}
+	p.items = append(p.items, e)
+}
+
+// Len shows how many executors are currently in the pool.
+func (p *ExecutorPool) Len() int {
+	return p.len()
+}
+
+// Run makes sure that the pool has at least `n` executors available.
+// Run spins up a new goroutine to maintain the pool.
+// The goroutine will exit when the context is cancelled, closing all the executors held by the pool.
+//
+// The returned context will have an error set if the pool fails to create an executor or is otherwise cancelled.
+func (p *ExecutorPool) Run(ctx context.Context, n int) (_ context.Context, cancel func()) {
+	return p.start(ctx, n)
+}
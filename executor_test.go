@@ -0,0 +1,202 @@
+package gonso
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestExecutor(t *testing.T) {
+	var hostNS string
+	cur, err := Current(NS_NET)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cur.Close()
+	if err := cur.Do(func() { hostNS = getNS(t, "net") }); err != nil {
+		t.Fatal(err)
+	}
+
+	var created int
+	p := NewExecutorPool(NS_NET|NS_UTS, func() error {
+		created++
+		return unix.Sethostname([]byte("gonso-executor"))
+	})
+
+	e, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created != 1 {
+		t.Errorf("expected after create to be called once, got %d", created)
+	}
+
+	var (
+		tid1, tid2 int
+		ns1, ns2   string
+		hostname   string
+	)
+	if err := e.Do(func() {
+		tid1 = unix.Gettid()
+		ns1 = getNS(t, "net")
+		var uts unix.Utsname
+		unix.Uname(&uts)
+		hostname = unix.ByteSliceToString(uts.Nodename[:])
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Do(func() {
+		tid2 = unix.Gettid()
+		ns2 = getNS(t, "net")
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if tid1 != tid2 {
+		t.Errorf("expected functions to run on the same thread: %d != %d", tid1, tid2)
+	}
+	if ns1 != ns2 {
+		t.Errorf("expected the same namespace: %s != %s", ns1, ns2)
+	}
+	if ns1 == hostNS {
+		t.Error("expected executor to be in a new namespace")
+	}
+	if hostname != "gonso-executor" {
+		t.Errorf("unexpected hostname: %s", hostname)
+	}
+
+	s, err := e.Set()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id := s.testGetID(t, NS_NET); id != ns1 {
+		t.Errorf("expected set to match executor namespace: %s != %s", id, ns1)
+	}
+	s.Close()
+
+	// The pool is not running so nothing would ever close the executor.
+	p.Put(e)
+	if p.Len() != 0 {
+		t.Fatal("expected executor to not be kept by a pool which is not running")
+	}
+	if err := e.Do(func() {}); !errors.Is(err, ErrExecutorClosed) {
+		t.Fatalf("expected closed error, got: %v", err)
+	}
+
+	p.notify = func() {
+		p.cvar.Signal()
+	}
+
+	ctx, cancelP := p.Run(context.Background(), 3)
+	defer cancelP()
+
+	ctxT, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	waitForExecutorPool(t, ctxT, p, 3)
+
+	e, err = p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Do(func() { tid1 = unix.Gettid() }); err != nil {
+		t.Fatal(err)
+	}
+	p.Put(e)
+
+	// The executor put back should still be on the same thread.
+	var recycled *Executor
+	p.mu.Lock()
+	for _, pe := range p.items {
+		if pe == e {
+			recycled = pe
+		}
+	}
+	p.mu.Unlock()
+	if recycled == nil {
+		t.Fatal("expected executor to be put back in the pool")
+	}
+	if err := recycled.Do(func() { tid2 = unix.Gettid() }); err != nil {
+		t.Fatal(err)
+	}
+	if tid1 != tid2 {
+		t.Error("expected recycled executor to use the same thread")
+	}
+
+	// Closed executors are dropped.
+	closed, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := closed.Close(); err != nil {
+		t.Fatal(err)
+	}
+	p.Put(closed)
+	p.mu.Lock()
+	for _, pe := range p.items {
+		if pe == closed {
+			t.Error("expected closed executor to not be put back in the pool")
+		}
+	}
+	p.mu.Unlock()
+
+	waitForExecutorPool(t, ctxT, p, 3)
+	p.mu.Lock()
+	execs := append([]*Executor(nil), p.items...)
+	p.mu.Unlock()
+
+	cancelP()
+	<-ctx.Done()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for p.Len() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for pool to drain")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	for _, e := range execs {
+		if err := e.Do(func() {}); !errors.Is(err, ErrExecutorClosed) {
+			t.Errorf("expected executor to be closed, got: %v", err)
+		}
+	}
+
+	// Executors put back once the pool has stopped would leak their thread.
+	e, err = p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Put(e)
+	if p.Len() != 0 {
+		t.Error("expected executor to not be kept after the pool stopped")
+	}
+	if err := e.Do(func() {}); !errors.Is(err, ErrExecutorClosed) {
+		t.Errorf("expected executor to be closed, got: %v", err)
+	}
+}
+
+func TestExecutorUserns(t *testing.T) {
+	_, err := NewExecutor(NS_USER, nil)
+	if !errors.Is(err, unix.EINVAL) {
+		t.Fatalf("expected EINVAL, got: %v", err)
+	}
+}
+
+func waitForExecutorPool(t *testing.T, ctx context.Context, p *ExecutorPool, n int) {
+	t.Helper()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for len(p.items) < n {
+		select {
+		case <-ctx.Done():
+			t.Fatal(ctx.Err())
+		default:
+		}
+		p.cvar.Wait()
+	}
+}
@@ -0,0 +1,570 @@
+package gonso
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// Dial connects to `address` on the named network from inside the set's
+// network namespace. The network must be "tcp", "tcp4", "tcp6", "udp", "udp4"
+// or "udp6", see `net.Dial` for the format of `address`.
+// The set must contain a network namespace.
+//
+// Host names and service names are resolved in the caller's namespaces since
+// the resolver does its work on other goroutines. The resulting addresses are
+// then tried one after another until one of them connects.
+//
+// Only the creation of the socket happens in the namespace, the returned
+// connection can be used from any goroutine.
+func (s Set) Dial(ctx context.Context, network, address string) (net.Conn, error) {
+	if _, ok := s.fds[unix.CLONE_NEWNET]; !ok {
+		return nil, fmt.Errorf("flag not in set for %s", nsFlagsReverse[unix.CLONE_NEWNET])
+	}
+	addrs, err := resolveDial(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	netns, err := s.Dup(unix.CLONE_NEWNET)
+	if err != nil {
+		return nil, err
+	}
+	defer netns.Close()
+
+	var conn net.Conn
+	if doErr := netns.Do(func() { conn, err = dialSerial(ctx, network, addrs) }); doErr != nil {
+		return nil, doErr
+	}
+	return conn, err
+}
+
+// resolveDial resolves `address` to the literal addresses to dial on `network`.
+func resolveDial(ctx context.Context, network, address string) ([]netip.AddrPort, error) {
+	var family string
+	switch network {
+	case "tcp", "udp":
+		family = "ip"
+	case "tcp4", "udp4":
+		family = "ip4"
+	case "tcp6", "udp6":
+		family = "ip6"
+	default:
+		return nil, fmt.Errorf("unsupported network %q: %w", network, unix.EINVAL)
+	}
+
+	host, service, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	port, err := net.DefaultResolver.LookupPort(ctx, network, service)
+	if err != nil {
+		return nil, err
+	}
+	var ips []netip.Addr
+	if ip, err := netip.ParseAddr(host); err == nil {
+		ips = []netip.Addr{ip}
+	} else if ips, err = net.DefaultResolver.LookupNetIP(ctx, family, host); err != nil {
+		return nil, err
+	}
+
+	var addrs []netip.AddrPort
+	for _, ip := range ips {
+		ip = ip.Unmap()
+		if (family == "ip4" && !ip.Is4()) || (family == "ip6" && !ip.Is6()) {
+			continue
+		}
+		addrs = append(addrs, netip.AddrPortFrom(ip, uint16(port)))
+	}
+	if len(addrs) == 0 {
+		return nil, &net.AddrError{Err: "no suitable address found", Addr: address}
+	}
+	return addrs, nil
+}
+
+// dialSerial connects to each of `addrs` in turn, returning the first
+// connection which succeeds or else the first error.
+// Dialing a literal address creates the socket on the calling goroutine, so
+// this can be used in `Set.Do`.
+func dialSerial(ctx context.Context, network string, addrs []netip.AddrPort) (net.Conn, error) {
+	var (
+		d        net.Dialer
+		firstErr error
+	)
+	for _, addr := range addrs {
+		conn, err := d.DialContext(ctx, network, addr.String())
+		if err == nil {
+			return conn, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, firstErr
+}
+
+// ForwarderOpts configures a `Forwarder`.
+type ForwarderOpts struct {
+	// DialTimeout limits how long connecting to the target may take.
+	// If this is 0, `DefaultForwarderDialTimeout` is used.
+	DialTimeout time.Duration
+	// UDPIdleTimeout is how long a UDP flow may go without traffic in either
+	// direction before it is closed.
+	// If this is 0, `DefaultUDPIdleTimeout` is used.
+	UDPIdleTimeout time.Duration
+}
+
+const (
+	// DefaultForwarderDialTimeout is the default value for `ForwarderOpts.DialTimeout`.
+	DefaultForwarderDialTimeout = 10 * time.Second
+	// DefaultUDPIdleTimeout is the default value for `ForwarderOpts.UDPIdleTimeout`.
+	DefaultUDPIdleTimeout = 30 * time.Second
+)
+
+// ForwarderStats are the counters of a `Forwarder`.
+type ForwarderStats struct {
+	// Active is the number of open TCP connections or UDP flows.
+	Active int64
+	// Total is the number of TCP connections or UDP flows which were forwarded.
+	Total int64
+	// Failed is the number of TCP connections or UDP flows which could not be
+	// forwarded because connecting to the target failed.
+	Failed int64
+	// BytesIn is the number of bytes sent from clients to the target.
+	BytesIn int64
+	// BytesOut is the number of bytes sent from the target to clients.
+	BytesOut int64
+}
+
+// Forwarder is a userspace port forwarder which accepts connections on the
+// caller's network namespace and relays them to a target address inside a
+// set's network namespace. This is an alternative to NAT (see `Set.ApplyNAT`)
+// which does not require any privileges in the caller's network namespace.
+//
+// Each TCP connection is relayed over a new connection to the target.
+// For UDP each client address is a flow with its own socket to the target,
+// which is closed once the flow has been idle for `ForwarderOpts.UDPIdleTimeout`.
+//
+// Sockets to the target are created in the set's network namespace, all relaying
+// is done from ordinary goroutines.
+type Forwarder struct {
+	set     Set
+	network string
+	target  string
+	opts    ForwarderOpts
+
+	ln net.Listener
+	pc net.PacketConn
+
+	// ctx is cancelled by `Close` to abort connections to the target which are still being dialed.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu     sync.Mutex
+	conns  map[io.Closer]struct{}
+	closed bool
+	wg     sync.WaitGroup
+	done   chan struct{}
+
+	closeOnce sync.Once
+	closeErr  error
+
+	active, total, failed, bytesIn, bytesOut atomic.Int64
+}
+
+// NewForwarder listens on `listenAddr` on the named network ("tcp", "tcp4",
+// "tcp6", "udp", "udp4" or "udp6") in the caller's network namespace and
+// forwards everything to `target` in the network namespace of `s`.
+// The set must contain a network namespace.
+//
+// The set is duplicated so the caller may close `s` once NewForwarder returns.
+// The caller is responsible for calling `Close` or `Shutdown` on the returned Forwarder.
+func NewForwarder(s Set, network, listenAddr, target string, opts ForwarderOpts) (_ *Forwarder, retErr error) {
+	if _, ok := s.fds[unix.CLONE_NEWNET]; !ok {
+		return nil, fmt.Errorf("flag not in set for %s", nsFlagsReverse[unix.CLONE_NEWNET])
+	}
+	if opts.DialTimeout <= 0 {
+		opts.DialTimeout = DefaultForwarderDialTimeout
+	}
+	if opts.UDPIdleTimeout <= 0 {
+		opts.UDPIdleTimeout = DefaultUDPIdleTimeout
+	}
+
+	netns, err := s.Dup(unix.CLONE_NEWNET)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if retErr != nil {
+			netns.Close()
+		}
+	}()
+
+	f := &Forwarder{
+		set:     netns,
+		network: network,
+		target:  target,
+		opts:    opts,
+		conns:   make(map[io.Closer]struct{}),
+		done:    make(chan struct{}),
+	}
+	f.ctx, f.cancel = context.WithCancel(context.Background())
+	defer func() {
+		if retErr != nil {
+			f.cancel()
+		}
+	}()
+
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+		f.ln, err = net.Listen(network, listenAddr)
+		if err != nil {
+			return nil, err
+		}
+		go f.serveTCP()
+	case "udp", "udp4", "udp6":
+		f.pc, err = net.ListenPacket(network, listenAddr)
+		if err != nil {
+			return nil, err
+		}
+		go f.serveUDP()
+	default:
+		return nil, fmt.Errorf("unsupported network %q: %w", network, unix.EINVAL)
+	}
+	return f, nil
+}
+
+// Addr returns the address the forwarder is listening on.
+func (f *Forwarder) Addr() net.Addr {
+	if f.ln != nil {
+		return f.ln.Addr()
+	}
+	return f.pc.LocalAddr()
+}
+
+// Stats returns a snapshot of the forwarder's counters.
+func (f *Forwarder) Stats() ForwarderStats {
+	return ForwarderStats{
+		Active:   f.active.Load(),
+		Total:    f.total.Load(),
+		Failed:   f.failed.Load(),
+		BytesIn:  f.bytesIn.Load(),
+		BytesOut: f.bytesOut.Load(),
+	}
+}
+
+// Close stops the forwarder and closes all open connections immediately.
+func (f *Forwarder) Close() error {
+	f.stop()
+	f.cancel()
+	f.closeConns()
+	f.wg.Wait()
+	<-f.done
+
+	f.closeOnce.Do(func() {
+		f.closeErr = f.set.Close()
+	})
+	return f.closeErr
+}
+
+// Shutdown stops accepting new connections and waits for open TCP connections
+// to finish. If `ctx` is done first the remaining connections are closed and
+// the context's error is returned.
+//
+// UDP flows can't tell when they are finished so they are closed right away.
+func (f *Forwarder) Shutdown(ctx context.Context) error {
+	f.stop()
+	if f.pc != nil {
+		f.closeConns()
+	}
+
+	finished := make(chan struct{})
+	go func() {
+		f.wg.Wait()
+		close(finished)
+	}()
+
+	var err error
+	select {
+	case <-finished:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// stop closes the listener.
+func (f *Forwarder) stop() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return
+	}
+	f.closed = true
+
+	if f.ln != nil {
+		f.ln.Close()
+	} else {
+		// Flows only live as long as the socket they are received on.
+		f.pc.Close()
+	}
+}
+
+// closeConns closes all open connections, any connection tracked afterwards is refused.
+func (f *Forwarder) closeConns() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for c := range f.conns {
+		c.Close()
+	}
+	f.conns = nil
+}
+
+// spawn runs `fn` in a new goroutine which `Close` and `Shutdown` wait for.
+// It returns false, without running `fn`, if the forwarder is already stopped.
+func (f *Forwarder) spawn(fn func()) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return false
+	}
+	f.wg.Add(1)
+	go func() {
+		defer f.wg.Done()
+		fn()
+	}()
+	return true
+}
+
+// track registers `c` as an active connection to be closed by `Close`.
+// It returns false if open connections have already been closed.
+func (f *Forwarder) track(c io.Closer) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.conns == nil {
+		return false
+	}
+	f.conns[c] = struct{}{}
+	f.active.Add(1)
+	f.total.Add(1)
+	return true
+}
+
+func (f *Forwarder) untrack(c io.Closer) {
+	f.mu.Lock()
+	delete(f.conns, c)
+	f.mu.Unlock()
+	f.active.Add(-1)
+}
+
+func (f *Forwarder) dial() (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(f.ctx, f.opts.DialTimeout)
+	defer cancel()
+
+	conn, err := f.set.Dial(ctx, f.network, f.target)
+	if err != nil {
+		f.failed.Add(1)
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (f *Forwarder) serveTCP() {
+	defer close(f.done)
+
+	for {
+		conn, err := f.ln.Accept()
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				continue
+			}
+			return
+		}
+		if !f.spawn(func() { f.forwardTCP(conn) }) {
+			conn.Close()
+			return
+		}
+	}
+}
+
+func (f *Forwarder) forwardTCP(client net.Conn) {
+	defer client.Close()
+
+	target, err := f.dial()
+	if err != nil {
+		return
+	}
+	defer target.Close()
+
+	pair := closers{client, target}
+	if !f.track(pair) {
+		return
+	}
+	defer f.untrack(pair)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		relay(target, client, &f.bytesIn)
+	}()
+	go func() {
+		defer wg.Done()
+		relay(client, target, &f.bytesOut)
+	}()
+	wg.Wait()
+}
+
+// relay copies from `src` to `dst` until `src` is done, then closes the write side of `dst`.
+// Bytes are counted as they are written so the stats include connections which are still open.
+func relay(dst, src net.Conn, counter *atomic.Int64) {
+	io.Copy(countingWriter{dst, counter}, src)
+
+	if cw, ok := dst.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+	} else {
+		dst.Close()
+	}
+}
+
+// countingWriter adds the number of bytes written to `w` to `n`.
+type countingWriter struct {
+	w io.Writer
+	n *atomic.Int64
+}
+
+func (cw countingWriter) Write(b []byte) (int, error) {
+	n, err := cw.w.Write(b)
+	cw.n.Add(int64(n))
+	return n, err
+}
+
+// closers closes all of its elements.
+type closers [2]io.Closer
+
+func (c closers) Close() error {
+	var retErr error
+	for _, cl := range c {
+		if err := cl.Close(); err != nil && retErr == nil {
+			retErr = err
+		}
+	}
+	return retErr
+}
+
+// udpFlow is the traffic from a single client address.
+type udpFlow struct {
+	conn       net.Conn
+	lastActive atomic.Int64
+}
+
+func (fl *udpFlow) touch() {
+	fl.lastActive.Store(time.Now().UnixNano())
+}
+
+func (fl *udpFlow) Close() error {
+	return fl.conn.Close()
+}
+
+func (f *Forwarder) serveUDP() {
+	defer close(f.done)
+
+	var (
+		mu    sync.Mutex
+		flows = make(map[string]*udpFlow)
+	)
+
+	buf := make([]byte, 1<<16)
+	for {
+		n, client, err := f.pc.ReadFrom(buf)
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				continue
+			}
+			return
+		}
+
+		key := client.String()
+		mu.Lock()
+		fl, ok := flows[key]
+		mu.Unlock()
+
+		if !ok {
+			conn, err := f.dial()
+			if err != nil {
+				continue
+			}
+			fl = &udpFlow{conn: conn}
+			fl.touch()
+			if !f.track(fl) {
+				conn.Close()
+				return
+			}
+
+			mu.Lock()
+			flows[key] = fl
+			mu.Unlock()
+
+			cleanup := func() {
+				mu.Lock()
+				delete(flows, key)
+				mu.Unlock()
+				fl.Close()
+				f.untrack(fl)
+			}
+			if !f.spawn(func() {
+				defer cleanup()
+				f.replyUDP(fl, client)
+			}) {
+				cleanup()
+				return
+			}
+		}
+
+		fl.touch()
+		if n, err := fl.conn.Write(buf[:n]); err == nil {
+			f.bytesIn.Add(int64(n))
+		}
+	}
+}
+
+// replyUDP relays replies from the target back to `client` until the flow is idle or closed.
+func (f *Forwarder) replyUDP(fl *udpFlow, client net.Addr) {
+	buf := make([]byte, 1<<16)
+	for {
+		idle := time.Until(time.Unix(0, fl.lastActive.Load()).Add(f.opts.UDPIdleTimeout))
+		if idle <= 0 {
+			return
+		}
+		fl.conn.SetReadDeadline(time.Now().Add(idle))
+
+		n, err := fl.conn.Read(buf)
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				// Check again, the client may have sent something in the meantime.
+				continue
+			}
+			return
+		}
+		fl.touch()
+		if n, err := f.pc.WriteTo(buf[:n], client); err == nil {
+			f.bytesOut.Add(int64(n))
+		}
+	}
+}
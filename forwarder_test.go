@@ -0,0 +1,349 @@
+package gonso
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestForwarderTCP(t *testing.T) {
+	for name, flags := range map[string]int{"netns": NS_NET, "userns": NS_NET | NS_USER} {
+		flags := flags
+		t.Run(name, func(t *testing.T) {
+			set, err := Unshare(flags, WithLoopback())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer set.Close()
+
+			l := listen(t, set, "tcp", "127.0.0.1:0")
+			defer l.Close()
+			go serveEcho(l)
+
+			f, err := NewForwarder(set, "tcp", "127.0.0.1:0", l.Addr().String(), ForwarderOpts{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			for i := 0; i < 2; i++ {
+				conn, err := net.Dial("tcp", f.Addr().String())
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, err := conn.Write([]byte("hello")); err != nil {
+					t.Fatal(err)
+				}
+				conn.(*net.TCPConn).CloseWrite()
+				data, err := io.ReadAll(conn)
+				conn.Close()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(data) != "hello" {
+					t.Errorf("unexpected data: %q", string(data))
+				}
+			}
+
+			waitStats(t, f, func(s ForwarderStats) bool {
+				return s.Active == 0 && s.Total == 2 && s.BytesIn == 10 && s.BytesOut == 10
+			})
+		})
+	}
+}
+
+func TestForwarderHostname(t *testing.T) {
+	set, err := Unshare(NS_NET, WithLoopback())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer set.Close()
+
+	l := listen(t, set, "tcp", "127.0.0.1:0")
+	defer l.Close()
+	go serveEcho(l)
+
+	// localhost may resolve to ::1 first, which nothing listens on, so this
+	// also checks that the remaining addresses are tried in the namespace.
+	_, port, err := net.SplitHostPort(l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := NewForwarder(set, "tcp", "127.0.0.1:0", net.JoinHostPort("localhost", port), ForwarderOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	conn, err := net.Dial("tcp", f.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "hello" {
+		t.Errorf("unexpected data: %q", string(buf))
+	}
+
+	// Bytes are counted while the connection is still open.
+	waitStats(t, f, func(s ForwarderStats) bool {
+		return s.Active == 1 && s.BytesIn == 5 && s.BytesOut == 5
+	})
+}
+
+func TestSetDialUnsupportedNetwork(t *testing.T) {
+	set, err := Unshare(NS_NET, WithLoopback())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer set.Close()
+
+	if _, err := set.Dial(context.Background(), "unix", "/tmp/sock"); !errors.Is(err, unix.EINVAL) {
+		t.Fatalf("expected EINVAL, got: %v", err)
+	}
+}
+
+func TestForwarderDialFailure(t *testing.T) {
+	set, err := Unshare(NS_NET, WithLoopback())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer set.Close()
+
+	// Nothing is listening on the target.
+	l := listen(t, set, "tcp", "127.0.0.1:0")
+	target := l.Addr().String()
+	l.Close()
+
+	f, err := NewForwarder(set, "tcp", "127.0.0.1:0", target, ForwarderOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	conn, err := net.Dial("tcp", f.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := io.ReadAll(conn); err != nil {
+		t.Fatal(err)
+	}
+
+	waitStats(t, f, func(s ForwarderStats) bool {
+		return s.Failed == 1 && s.Total == 0 && s.Active == 0
+	})
+}
+
+func TestForwarderCloseWhileDialing(t *testing.T) {
+	set, err := Unshare(NS_NET, WithLoopback())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer set.Close()
+
+	// Nothing answers on the other end of the veth so connecting to the
+	// target hangs until neighbour resolution gives up.
+	if err := Veth(set, set, "veth0", "veth1", VethOpts{Up: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := set.AddrAdd("veth0", netip.MustParsePrefix("10.77.0.1/24")); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := NewForwarder(set, "tcp", "127.0.0.1:0", "10.77.0.2:80", ForwarderOpts{DialTimeout: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := net.Dial("tcp", f.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	start := time.Now()
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("expected close to abort the pending dial, took %s", d)
+	}
+	if s := f.Stats(); s.Failed != 1 || s.Total != 0 {
+		t.Errorf("unexpected stats: %+v", s)
+	}
+}
+
+func TestForwarderShutdown(t *testing.T) {
+	set, err := Unshare(NS_NET, WithLoopback())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer set.Close()
+
+	l := listen(t, set, "tcp", "127.0.0.1:0")
+	defer l.Close()
+	go serveEcho(l)
+
+	newConn := func(f *Forwarder) net.Conn {
+		t.Helper()
+		conn, err := net.Dial("tcp", f.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		waitStats(t, f, func(s ForwarderStats) bool { return s.Active == 1 })
+		return conn
+	}
+
+	t.Run("graceful", func(t *testing.T) {
+		f, err := NewForwarder(set, "tcp", "127.0.0.1:0", l.Addr().String(), ForwarderOpts{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn := newConn(f)
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			conn.Close()
+		}()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := f.Shutdown(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := net.Dial("tcp", f.Addr().String()); err == nil {
+			t.Error("expected listener to be closed")
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		f, err := NewForwarder(set, "tcp", "127.0.0.1:0", l.Addr().String(), ForwarderOpts{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn := newConn(f)
+		defer conn.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		if err := f.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("expected deadline exceeded, got: %v", err)
+		}
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if _, err := io.ReadAll(conn); err != nil {
+			t.Errorf("expected connection to be closed, got: %v", err)
+		}
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestForwarderUDP(t *testing.T) {
+	set, err := Unshare(NS_NET, WithLoopback())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer set.Close()
+
+	var pc net.PacketConn
+	doNet(t, set, func() (err error) {
+		pc, err = net.ListenPacket("udp", "127.0.0.1:0")
+		return err
+	})
+	defer pc.Close()
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			pc.WriteTo(buf[:n], addr)
+		}
+	}()
+
+	f, err := NewForwarder(set, "udp", "127.0.0.1:0", pc.LocalAddr().String(), ForwarderOpts{UDPIdleTimeout: 200 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	clients := make([]net.Conn, 2)
+	for i := range clients {
+		conn, err := net.Dial("udp", f.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		clients[i] = conn
+	}
+
+	buf := make([]byte, 1024)
+	for i := 0; i < 2; i++ {
+		for _, conn := range clients {
+			if _, err := conn.Write([]byte("ping")); err != nil {
+				t.Fatal(err)
+			}
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			n, err := conn.Read(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(buf[:n]) != "ping" {
+				t.Errorf("unexpected data: %q", string(buf[:n]))
+			}
+		}
+	}
+
+	// Each client is a single flow.
+	stats := f.Stats()
+	if stats.Total != 2 || stats.BytesIn != 16 || stats.BytesOut != 16 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+
+	// Flows are closed once idle.
+	waitStats(t, f, func(s ForwarderStats) bool { return s.Active == 0 })
+}
+
+func serveEcho(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			io.Copy(conn, conn)
+			conn.Close()
+		}()
+	}
+}
+
+// waitStats waits for the forwarder's stats to satisfy `cond`.
+func waitStats(t *testing.T, f *Forwarder, cond func(ForwarderStats) bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		s := f.Stats()
+		if cond(s) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for stats, got: %+v", s)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
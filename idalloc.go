@@ -0,0 +1,327 @@
+package gonso
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// ErrIDRangeExhausted is returned when an `IDAllocator` does not have enough free ids left.
+var ErrIDRangeExhausted = errors.New("not enough free ids")
+
+// IDAllocator carves non-overlapping ranges of host ids out of a larger pool
+// so that many user namespaces can be created at the same time without
+// sharing host ids.
+//
+// Allocations are persisted to a state file which is locked while it is being
+// updated, so multiple allocators (including ones in other processes) can
+// share the same pool as long as they use the same state file.
+type IDAllocator struct {
+	path string
+	uids []SubIDRange
+	gids []SubIDRange
+}
+
+// NewIDAllocator creates an allocator which hands out ids from the given
+// uid and gid ranges and records allocations in the file at `statePath`.
+func NewIDAllocator(statePath string, uids, gids []SubIDRange) (*IDAllocator, error) {
+	if len(uids) == 0 || len(gids) == 0 {
+		return nil, fmt.Errorf("both uid and gid ranges are required: %w", unix.EINVAL)
+	}
+	return &IDAllocator{path: statePath, uids: uids, gids: gids}, nil
+}
+
+// NewSubIDAllocator creates an allocator which hands out the subordinate ids
+// of the given user from /etc/subuid and /etc/subgid.
+// See `NewIDAllocator`.
+func NewSubIDAllocator(statePath, user string) (*IDAllocator, error) {
+	uids, err := LookupSubUIDs(user)
+	if err != nil {
+		return nil, err
+	}
+	gids, err := LookupSubGIDs(user)
+	if err != nil {
+		return nil, err
+	}
+	return NewIDAllocator(statePath, uids, gids)
+}
+
+type idAllocState = allocState[idAllocRecord]
+
+type idAllocRecord struct {
+	allocRecord
+	// Namespaces are the ids (see `Set.ID`) of the namespaces of the set
+	// using the allocation, once it has been created.
+	Namespaces []string `json:"namespaces,omitempty"`
+	// Pid is the process creating a set for the allocation, while the set
+	// is not created yet.
+	Pid  int `json:"pid,omitempty"`
+	UID  int `json:"uid"`
+	GID  int `json:"gid"`
+	Size int `json:"size"`
+}
+
+// live reports whether the ids of the allocation may still be in use.
+//
+// An allocation tied to a set is in use for as long as any of the set's
+// namespaces are, which covers duplicates of the set and anything else
+// holding on to the namespaces.
+// Allocations from `Allocate` are in use until they are released.
+func (r idAllocRecord) live() bool {
+	if len(r.Namespaces) > 0 {
+		return namespacesInUse(r.Namespaces)
+	}
+	if r.Pid != 0 {
+		return processExists(r.Pid)
+	}
+	return true
+}
+
+// IDAllocation is a range of host uids and gids handed out by an `IDAllocator`.
+type IDAllocation struct {
+	// ID identifies the allocation in the allocator's state.
+	ID string
+	// UidMaps maps ids 0 through the size of the allocation to the allocated host uids.
+	UidMaps []IDMap
+	// GidMaps maps ids 0 through the size of the allocation to the allocated host gids.
+	GidMaps []IDMap
+
+	a    *IDAllocator
+	once sync.Once
+	err  error
+}
+
+// Allocate reserves `size` host uids and gids.
+// The returned mappings are ready to be used with `WithIDMaps`.
+//
+// The caller is responsible for calling `Release` on the allocation once it
+// is no longer needed, the ids are not handed out again until then.
+// See `IDAllocator.WithIDMaps` for allocations which follow the lifetime of a set.
+func (a *IDAllocator) Allocate(size int) (*IDAllocation, error) {
+	return a.allocate(size, 0)
+}
+
+// allocate is Allocate with the pid of the process creating a set for the
+// allocation, if any.
+func (a *IDAllocator) allocate(size, pid int) (*IDAllocation, error) {
+	if size <= 0 {
+		return nil, fmt.Errorf("invalid allocation size %d: %w", size, unix.EINVAL)
+	}
+
+	id, err := newAllocationID()
+	if err != nil {
+		return nil, err
+	}
+
+	var rec idAllocRecord
+	err = updateState(a.path, func(state *idAllocState) error {
+		state.prune(idAllocRecord.live)
+
+		var used []SubIDRange
+		for _, r := range state.Allocations {
+			used = append(used, SubIDRange{Start: r.UID, Count: r.Size})
+		}
+		uid, ok := findFreeRange(a.uids, used, size)
+		if !ok {
+			return fmt.Errorf("error allocating %d uids: %w", size, ErrIDRangeExhausted)
+		}
+
+		used = used[:0]
+		for _, r := range state.Allocations {
+			used = append(used, SubIDRange{Start: r.GID, Count: r.Size})
+		}
+		gid, ok := findFreeRange(a.gids, used, size)
+		if !ok {
+			return fmt.Errorf("error allocating %d gids: %w", size, ErrIDRangeExhausted)
+		}
+
+		rec = idAllocRecord{allocRecord: allocRecord{ID: id}, Pid: pid, UID: uid, GID: gid, Size: size}
+		state.Allocations = append(state.Allocations, rec)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &IDAllocation{
+		ID:      id,
+		UidMaps: []IDMap{{ContainerID: 0, HostID: rec.UID, Size: size}},
+		GidMaps: []IDMap{{ContainerID: 0, HostID: rec.GID, Size: size}},
+		a:       a,
+	}, nil
+}
+
+// Release returns the allocation's ids to the allocator.
+// It is safe to call Release multiple times.
+func (a *IDAllocation) Release() error {
+	a.once.Do(func() {
+		a.err = releaseRecord[idAllocRecord](a.a.path, a.ID)
+	})
+	return a.err
+}
+
+// bind ties the allocation to the namespaces of `s`, see `idAllocRecord.live`.
+func (a *IDAllocation) bind(s Set) error {
+	var ids []string
+	for kind := range s.fds {
+		id, err := s.ID(kind)
+		if err != nil {
+			return err
+		}
+		ids = append(ids, id)
+	}
+
+	return updateState(a.a.path, func(state *idAllocState) error {
+		for i, r := range state.Allocations {
+			if r.ID == a.ID {
+				state.Allocations[i].Namespaces = ids
+				state.Allocations[i].Pid = 0
+				return nil
+			}
+		}
+		return fmt.Errorf("allocation %s not found: %w", a.ID, unix.ENOENT)
+	})
+}
+
+// releaseUnused releases an allocation made by `WithIDMaps` unless the
+// namespaces it is tied to are still in use.
+func (a *IDAllocation) releaseUnused() error {
+	return updateState(a.a.path, func(state *idAllocState) error {
+		for _, r := range state.Allocations {
+			if r.ID == a.ID && len(r.Namespaces) > 0 && r.live() {
+				// Left for `Allocate` to reclaim once the namespaces are gone.
+				return nil
+			}
+		}
+		state.remove(a.ID)
+		return nil
+	})
+}
+
+// WithIDMaps allocates `size` host uids and gids and uses them as the id
+// mappings for the new user namespace.
+// It can be used as an UnshareOpt to configure the Unshare function.
+//
+// A new allocation is made each time a set is created with the option, which
+// makes it suitable for use with a `Pool`. The allocation is released if
+// creating the set fails.
+//
+// Otherwise the allocation is tied to the namespaces of the new set rather
+// than to the `Set` value: `Set.Dup` does not carry over the release, and
+// the ids must not be handed out again while anything can still enter the
+// user namespace. When the set is closed the allocation is released if none
+// of its namespaces are in use any more, e.g. by a duplicate of the set or by
+// a process, and otherwise it is reclaimed by a later call to `Allocate`
+// once they are gone.
+// Namespaces which are only kept alive by a bind mount (see `Set.Mount`) or
+// by a nested namespace are not seen as in use.
+func (a *IDAllocator) WithIDMaps(size int) UnshareOpt {
+	return func(c *UnshareConfig) {
+		c.beforeCreate = append(c.beforeCreate, func(c *UnshareConfig) (func() error, error) {
+			alloc, err := a.allocate(size, os.Getpid())
+			if err != nil {
+				return nil, err
+			}
+			c.UidMaps = alloc.UidMaps
+			c.GidMaps = alloc.GidMaps
+			c.afterCreate = append(c.afterCreate, func(s Set) (func() error, error) {
+				return nil, alloc.bind(s)
+			})
+			return alloc.releaseUnused, nil
+		})
+	}
+}
+
+// findFreeRange finds the first `size` ids in `ranges` which do not overlap with `used`.
+func findFreeRange(ranges, used []SubIDRange, size int) (int, bool) {
+	sort.Slice(used, func(i, j int) bool {
+		return used[i].Start < used[j].Start
+	})
+
+	for _, r := range ranges {
+		start := r.Start
+		end := r.Start + r.Count
+		for _, u := range used {
+			if start+size <= u.Start {
+				break
+			}
+			if u.Start+u.Count > start {
+				start = u.Start + u.Count
+			}
+		}
+		if start+size <= end {
+			return start, true
+		}
+	}
+	return 0, false
+}
+
+func newAllocationID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func processExists(pid int) bool {
+	err := unix.Kill(pid, 0)
+	return err == nil || err == unix.EPERM
+}
+
+// namespacesInUse reports whether any thread is in, or any process has an fd
+// open for, one of the namespaces with the given ids.
+// Processes which can't be inspected are skipped.
+func namespacesInUse(ids []string) bool {
+	want := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		want[id] = true
+	}
+
+	procs, err := os.ReadDir("/proc")
+	if err != nil {
+		// Err on the side of not handing out ids which may be in use.
+		return true
+	}
+
+	linksMatch := func(dir string) bool {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return false
+		}
+		for _, e := range entries {
+			if l, err := os.Readlink(filepath.Join(dir, e.Name())); err == nil && want[l] {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, p := range procs {
+		if _, err := strconv.Atoi(p.Name()); err != nil {
+			continue
+		}
+		dir := filepath.Join("/proc", p.Name())
+		if linksMatch(filepath.Join(dir, "fd")) {
+			return true
+		}
+		tasks, err := os.ReadDir(filepath.Join(dir, "task"))
+		if err != nil {
+			continue
+		}
+		for _, t := range tasks {
+			if linksMatch(filepath.Join(dir, "task", t.Name(), "ns")) {
+				return true
+			}
+		}
+	}
+	return false
+}
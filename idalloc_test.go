@@ -0,0 +1,213 @@
+package gonso
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestFindFreeRange(t *testing.T) {
+	ranges := []SubIDRange{{Start: 100, Count: 10}, {Start: 1000, Count: 100}}
+
+	cases := []struct {
+		name  string
+		used  []SubIDRange
+		size  int
+		start int
+		ok    bool
+	}{
+		{name: "empty", size: 10, start: 100, ok: true},
+		{name: "too big", size: 101},
+		{name: "skip full range", used: []SubIDRange{{Start: 100, Count: 10}}, size: 1, start: 1000, ok: true},
+		{name: "gap", used: []SubIDRange{{Start: 100, Count: 2}, {Start: 105, Count: 5}}, size: 3, start: 102, ok: true},
+		{name: "gap too small", used: []SubIDRange{{Start: 100, Count: 2}, {Start: 105, Count: 5}}, size: 4, start: 1000, ok: true},
+		{name: "unsorted", used: []SubIDRange{{Start: 1010, Count: 10}, {Start: 1000, Count: 10}}, size: 20, start: 1020, ok: true},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			start, ok := findFreeRange(ranges, tc.used, tc.size)
+			if ok != tc.ok {
+				t.Fatalf("expected ok=%v, got %v", tc.ok, ok)
+			}
+			if ok && start != tc.start {
+				t.Errorf("expected start %d, got %d", tc.start, start)
+			}
+		})
+	}
+}
+
+func TestIDAllocator(t *testing.T) {
+	statePath := filepath.Join(t.TempDir(), "state.json")
+
+	uids := []SubIDRange{{Start: 100000, Count: 30}}
+	gids := []SubIDRange{{Start: 200000, Count: 30}}
+	a, err := NewIDAllocator(statePath, uids, gids)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var allocs []*IDAllocation
+	for i := 0; i < 3; i++ {
+		alloc, err := a.Allocate(10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		allocs = append(allocs, alloc)
+
+		if alloc.UidMaps[0].HostID != 100000+i*10 {
+			t.Errorf("unexpected uid map: %+v", alloc.UidMaps)
+		}
+		if alloc.GidMaps[0].HostID != 200000+i*10 {
+			t.Errorf("unexpected gid map: %+v", alloc.GidMaps)
+		}
+	}
+
+	if _, err := a.Allocate(1); !errors.Is(err, ErrIDRangeExhausted) {
+		t.Fatalf("expected exhausted error, got: %v", err)
+	}
+
+	if err := allocs[1].Release(); err != nil {
+		t.Fatal(err)
+	}
+	if err := allocs[1].Release(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A separate allocator sharing the state file should see the same allocations.
+	b, err := NewIDAllocator(statePath, uids, gids)
+	if err != nil {
+		t.Fatal(err)
+	}
+	alloc, err := b.Allocate(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if alloc.UidMaps[0].HostID != 100010 {
+		t.Errorf("expected released range to be reused: %+v", alloc.UidMaps)
+	}
+	alloc.Release()
+	allocs[0].Release()
+	allocs[2].Release()
+
+	addRecord := func(r idAllocRecord) {
+		t.Helper()
+		err := updateState(statePath, func(state *idAllocState) error {
+			state.Allocations = append(state.Allocations, r)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Allocations from processes that went away before creating their set are reclaimed.
+	addRecord(idAllocRecord{allocRecord: allocRecord{ID: "stale"}, Pid: 1 << 30, UID: 100000, GID: 200000, Size: 30})
+	alloc, err = a.Allocate(30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	alloc.Release()
+
+	// As are allocations tied to namespaces which are gone.
+	addRecord(idAllocRecord{allocRecord: allocRecord{ID: "gone"}, Namespaces: []string{"user:[1]"}, UID: 100000, GID: 200000, Size: 30})
+	alloc, err = a.Allocate(30)
+	if err != nil {
+		t.Fatal(err)
+	}
+	alloc.Release()
+
+	// But not ones tied to namespaces which are still around, regardless of
+	// the process which made them.
+	cur, err := Current(NS_NET)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cur.Close()
+	addRecord(idAllocRecord{allocRecord: allocRecord{ID: "inuse"}, Namespaces: []string{cur.testGetID(t, NS_NET)}, Pid: 1 << 30, UID: 100000, GID: 200000, Size: 30})
+	if _, err := a.Allocate(1); !errors.Is(err, ErrIDRangeExhausted) {
+		t.Fatalf("expected exhausted error, got: %v", err)
+	}
+}
+
+func TestIDAllocatorUnshare(t *testing.T) {
+	statePath := filepath.Join(t.TempDir(), "state.json")
+	a, err := NewIDAllocator(statePath, []SubIDRange{{Start: 100000, Count: 1000}}, []SubIDRange{{Start: 200000, Count: 1000}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	countAllocs := func() int {
+		var n int
+		updateState(statePath, func(state *idAllocState) error {
+			n = len(state.Allocations)
+			return nil
+		})
+		return n
+	}
+
+	// Nothing is allocated until a set is created with the option.
+	opt := a.WithIDMaps(100)
+	if n := countAllocs(); n != 0 {
+		t.Fatalf("expected no allocations, got %d", n)
+	}
+
+	sets, err := UnshareN(NS_USER|NS_NET, 3, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := countAllocs(); n != 3 {
+		t.Fatalf("expected 3 allocations, got %d", n)
+	}
+
+	for i, s := range sets {
+		maps := []IDMap{{ContainerID: 0, HostID: 100000 + i*100, Size: 100}}
+		gidMaps := []IDMap{{ContainerID: 0, HostID: 200000 + i*100, Size: 100}}
+		checkIDMaps(t, s, maps, gidMaps)
+	}
+
+	for _, s := range sets {
+		if err := s.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := countAllocs(); n != 0 {
+		t.Fatalf("expected allocations to be released, got %d", n)
+	}
+
+	// The ids stay allocated while a duplicate of the set is still around.
+	s, err := Unshare(NS_USER|NS_NET, a.WithIDMaps(1000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dup, err := s.Dup(NS_NET)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n := countAllocs(); n != 1 {
+		t.Fatalf("expected allocation to be kept for the duplicate, got %d", n)
+	}
+	if _, err := a.Allocate(1); !errors.Is(err, ErrIDRangeExhausted) {
+		t.Fatalf("expected exhausted error, got: %v", err)
+	}
+	if err := dup.Close(); err != nil {
+		t.Fatal(err)
+	}
+	alloc, err := a.Allocate(1000)
+	if err != nil {
+		t.Fatalf("expected allocation to be reclaimed once the duplicate is closed: %v", err)
+	}
+	alloc.Release()
+
+	// The allocation is released when creating the set fails.
+	if _, err := Unshare(NS_NET, a.WithIDMaps(100)); err == nil {
+		t.Fatal("expected error without a user namespace")
+	}
+	if n := countAllocs(); n != 0 {
+		t.Fatalf("expected allocations to be released, got %d", n)
+	}
+}
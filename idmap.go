@@ -0,0 +1,241 @@
+package gonso
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// maxIDMapExtents is the maximum number of lines the kernel accepts in a uid_map or gid_map file.
+	maxIDMapExtents = 340
+	// maxID is the largest value that can be used in a mapping, (uid_t)-1 is not a valid id.
+	maxID = 1<<32 - 1
+)
+
+// validateIDMaps checks the mappings against the rules the kernel enforces
+// when writing uid_map and gid_map so errors are caught before any process is created.
+// See user_namespaces(7).
+func validateIDMaps(maps []IDMap) error {
+	if len(maps) > maxIDMapExtents {
+		return fmt.Errorf("too many mappings, %d is more than the kernel limit of %d: %w", len(maps), maxIDMapExtents, unix.EINVAL)
+	}
+
+	for i, m := range maps {
+		if m.Size <= 0 {
+			return fmt.Errorf("mapping %q has an invalid size: %w", idMap(m), unix.EINVAL)
+		}
+		if m.ContainerID < 0 || m.HostID < 0 {
+			return fmt.Errorf("mapping %q has a negative id: %w", idMap(m), unix.EINVAL)
+		}
+		if int64(m.ContainerID)+int64(m.Size) > maxID || int64(m.HostID)+int64(m.Size) > maxID {
+			return fmt.Errorf("mapping %q is out of range: %w", idMap(m), unix.EINVAL)
+		}
+
+		for _, other := range maps[:i] {
+			if overlaps(m.ContainerID, other.ContainerID, m.Size, other.Size) {
+				return fmt.Errorf("mapping %q overlaps with %q in the container range: %w", idMap(m), idMap(other), unix.EINVAL)
+			}
+			if overlaps(m.HostID, other.HostID, m.Size, other.Size) {
+				return fmt.Errorf("mapping %q overlaps with %q in the host range: %w", idMap(m), idMap(other), unix.EINVAL)
+			}
+		}
+	}
+	return nil
+}
+
+func overlaps(a, b, aSize, bSize int) bool {
+	return int64(a) < int64(b)+int64(bSize) && int64(b) < int64(a)+int64(aSize)
+}
+
+// ParseIDMap parses a single id mapping.
+//
+// Both the colon separated form commonly used in configuration files
+// (`0:100000:65536`) and the whitespace separated form used by the kernel in
+// /proc/<pid>/uid_map (`0 100000 65536`) are supported.
+// The fields are, in order, the id inside the user namespace, the id outside
+// of the user namespace and the size of the range.
+func ParseIDMap(s string) (IDMap, error) {
+	s = strings.TrimSpace(s)
+
+	var fields []string
+	if strings.Contains(s, ":") {
+		fields = strings.Split(s, ":")
+	} else {
+		fields = strings.Fields(s)
+	}
+	if len(fields) != 3 {
+		return IDMap{}, fmt.Errorf("invalid id mapping %q: expected 3 fields, got %d", s, len(fields))
+	}
+
+	var m IDMap
+	for i, v := range []*int{&m.ContainerID, &m.HostID, &m.Size} {
+		n, err := strconv.ParseUint(fields[i], 10, 32)
+		if err != nil {
+			return IDMap{}, fmt.Errorf("invalid id mapping %q: %w", s, err)
+		}
+		*v = int(n)
+	}
+	return m, nil
+}
+
+// ParseIDMaps parses a list of id mappings.
+// Mappings may be separated by newlines or commas, for example
+// `0:1000:1,1:100000:65536` or the contents of a uid_map file.
+// Blank entries are ignored.
+//
+// See `ParseIDMap` for the format of each mapping.
+func ParseIDMaps(s string) ([]IDMap, error) {
+	var maps []IDMap
+	for _, line := range strings.Split(s, "\n") {
+		for _, entry := range strings.Split(line, ",") {
+			if strings.TrimSpace(entry) == "" {
+				continue
+			}
+			m, err := ParseIDMap(entry)
+			if err != nil {
+				return nil, err
+			}
+			maps = append(maps, m)
+		}
+	}
+	return maps, nil
+}
+
+// ReadIDMaps reads id mappings in the kernel's uid_map format from `r`.
+func ReadIDMaps(r io.Reader) ([]IDMap, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return ParseIDMaps(string(data))
+}
+
+// PidIDMaps reads the uid and gid mappings of the user namespace of the given process
+// from /proc/<pid>/uid_map and /proc/<pid>/gid_map.
+func PidIDMaps(pid int) (uidMaps, gidMaps []IDMap, _ error) {
+	uidMaps, err := readIDMapsFile(fmt.Sprintf("/proc/%d/uid_map", pid))
+	if err != nil {
+		return nil, nil, err
+	}
+	gidMaps, err = readIDMapsFile(fmt.Sprintf("/proc/%d/gid_map", pid))
+	if err != nil {
+		return nil, nil, err
+	}
+	return uidMaps, gidMaps, nil
+}
+
+func readIDMapsFile(p string) ([]IDMap, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	maps, err := ReadIDMaps(f)
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", p, err)
+	}
+	return maps, nil
+}
+
+// FormatIDMaps formats the mappings in the format expected by the kernel when
+// writing to /proc/<pid>/uid_map or /proc/<pid>/gid_map, one mapping per line.
+func FormatIDMaps(maps []IDMap) string {
+	var b strings.Builder
+	for _, m := range maps {
+		b.WriteString(idMap(m))
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// ErrUnmappedID is returned when an id has no mapping in a user namespace.
+var ErrUnmappedID = errors.New("id is not mapped")
+
+// MapID translates `id` from inside a user namespace to the corresponding id
+// outside of it using the given mappings.
+func MapID(maps []IDMap, id int) (int, error) {
+	for _, m := range maps {
+		if id >= m.ContainerID && id-m.ContainerID < m.Size {
+			return m.HostID + (id - m.ContainerID), nil
+		}
+	}
+	return -1, fmt.Errorf("%w: %d", ErrUnmappedID, id)
+}
+
+// UnmapID translates `id` from outside a user namespace to the corresponding
+// id inside of it using the given mappings.
+// This is the reverse of `MapID`.
+func UnmapID(maps []IDMap, id int) (int, error) {
+	for _, m := range maps {
+		if id >= m.HostID && id-m.HostID < m.Size {
+			return m.ContainerID + (id - m.HostID), nil
+		}
+	}
+	return -1, fmt.Errorf("%w: %d", ErrUnmappedID, id)
+}
+
+// IDMaps returns the uid and gid mappings of the set's user namespace.
+//
+// The host side of the mappings is relative to the caller's user namespace,
+// so mappings of nested user namespaces are already translated all the way
+// through to the caller's ids.
+//
+// The set must contain a user namespace.
+// A short lived process is created in the user namespace to read the
+// mappings, so if many ids need to be translated it is best to call this once
+// and use `MapID` and `UnmapID` with the returned mappings.
+func (s Set) IDMaps() (uidMaps, gidMaps []IDMap, _ error) {
+	err := withUserNSProcess(s, func(pid int) error {
+		var err error
+		uidMaps, gidMaps, err = PidIDMaps(pid)
+		return err
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("error reading id maps: %w", err)
+	}
+	return uidMaps, gidMaps, nil
+}
+
+// MapUID translates a uid in the set's user namespace to the caller's user namespace.
+// For example, MapUID(0) tells you which uid root in the set is on the host.
+func (s Set) MapUID(uid int) (int, error) {
+	uidMaps, _, err := s.IDMaps()
+	if err != nil {
+		return -1, err
+	}
+	return MapID(uidMaps, uid)
+}
+
+// MapGID translates a gid in the set's user namespace to the caller's user namespace.
+func (s Set) MapGID(gid int) (int, error) {
+	_, gidMaps, err := s.IDMaps()
+	if err != nil {
+		return -1, err
+	}
+	return MapID(gidMaps, gid)
+}
+
+// UnmapUID translates a uid in the caller's user namespace to the set's user namespace.
+func (s Set) UnmapUID(uid int) (int, error) {
+	uidMaps, _, err := s.IDMaps()
+	if err != nil {
+		return -1, err
+	}
+	return UnmapID(uidMaps, uid)
+}
+
+// UnmapGID translates a gid in the caller's user namespace to the set's user namespace.
+func (s Set) UnmapGID(gid int) (int, error) {
+	_, gidMaps, err := s.IDMaps()
+	if err != nil {
+		return -1, err
+	}
+	return UnmapID(gidMaps, gid)
+}
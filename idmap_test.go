@@ -0,0 +1,349 @@
+package gonso
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestValidateIDMaps(t *testing.T) {
+	tooMany := make([]IDMap, maxIDMapExtents+1)
+	for i := range tooMany {
+		tooMany[i] = IDMap{ContainerID: i, HostID: i, Size: 1}
+	}
+
+	cases := []struct {
+		name  string
+		maps  []IDMap
+		valid bool
+	}{
+		{name: "empty", valid: true},
+		{name: "single", maps: []IDMap{{ContainerID: 0, HostID: 1000, Size: 1}}, valid: true},
+		{
+			name: "adjacent",
+			maps: []IDMap{
+				{ContainerID: 0, HostID: 1000, Size: 10},
+				{ContainerID: 10, HostID: 1010, Size: 10},
+			},
+			valid: true,
+		},
+		{name: "max extents", maps: tooMany[:maxIDMapExtents], valid: true},
+		{name: "too many extents", maps: tooMany},
+		{name: "zero size", maps: []IDMap{{ContainerID: 0, HostID: 1000, Size: 0}}},
+		{name: "negative id", maps: []IDMap{{ContainerID: -1, HostID: 1000, Size: 1}}},
+		{
+			name: "container overlap",
+			maps: []IDMap{
+				{ContainerID: 0, HostID: 1000, Size: 10},
+				{ContainerID: 9, HostID: 2000, Size: 10},
+			},
+		},
+		{
+			name: "host overlap",
+			maps: []IDMap{
+				{ContainerID: 0, HostID: 1000, Size: 10},
+				{ContainerID: 100, HostID: 990, Size: 11},
+			},
+		},
+	}
+
+	if strconv.IntSize == 64 {
+		// Only 64-bit platforms can represent ids this large in an int.
+		var max int64 = maxID
+		cases = append(cases, []struct {
+			name  string
+			maps  []IDMap
+			valid bool
+		}{
+			{name: "container overflow", maps: []IDMap{{ContainerID: 1, HostID: 0, Size: int(max)}}},
+			{name: "host overflow", maps: []IDMap{{ContainerID: 0, HostID: int(max), Size: 1}}},
+		}...)
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			err := validateIDMaps(tc.maps)
+			if tc.valid {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if !errors.Is(err, unix.EINVAL) {
+				t.Fatalf("expected EINVAL, got: %v", err)
+			}
+		})
+	}
+}
+
+func TestMapID(t *testing.T) {
+	maps := []IDMap{
+		{ContainerID: 0, HostID: 1000, Size: 1},
+		{ContainerID: 1, HostID: 100000, Size: 65536},
+	}
+
+	for _, tc := range []struct {
+		container, host int
+	}{
+		{0, 1000},
+		{1, 100000},
+		{65536, 165535},
+	} {
+		host, err := MapID(maps, tc.container)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if host != tc.host {
+			t.Errorf("expected %d to map to %d, got %d", tc.container, tc.host, host)
+		}
+
+		container, err := UnmapID(maps, tc.host)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if container != tc.container {
+			t.Errorf("expected %d to unmap to %d, got %d", tc.host, tc.container, container)
+		}
+	}
+
+	if _, err := MapID(maps, 65537); !errors.Is(err, ErrUnmappedID) {
+		t.Errorf("expected unmapped error, got: %v", err)
+	}
+	if _, err := UnmapID(maps, 0); !errors.Is(err, ErrUnmappedID) {
+		t.Errorf("expected unmapped error, got: %v", err)
+	}
+}
+
+func TestSetIDMaps(t *testing.T) {
+	uidMaps := []IDMap{
+		{ContainerID: 0, HostID: 100000, Size: 1000},
+	}
+	gidMaps := []IDMap{
+		{ContainerID: 0, HostID: 200000, Size: 500},
+		{ContainerID: 1000, HostID: 300000, Size: 10},
+	}
+
+	set, err := Unshare(NS_USER|NS_NET, WithIDMaps(uidMaps, gidMaps))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer set.Close()
+
+	uids, gids, err := set.IDMaps()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(uids) != fmt.Sprint(uidMaps) {
+		t.Errorf("expected uid maps %v, got %v", uidMaps, uids)
+	}
+	if fmt.Sprint(gids) != fmt.Sprint(gidMaps) {
+		t.Errorf("expected gid maps %v, got %v", gidMaps, gids)
+	}
+
+	if uid, err := set.MapUID(0); err != nil || uid != 100000 {
+		t.Errorf("expected uid 0 to map to 100000, got %d: %v", uid, err)
+	}
+	if gid, err := set.MapGID(1005); err != nil || gid != 300005 {
+		t.Errorf("expected gid 1005 to map to 300005, got %d: %v", gid, err)
+	}
+	if uid, err := set.UnmapUID(100999); err != nil || uid != 999 {
+		t.Errorf("expected uid 100999 to unmap to 999, got %d: %v", uid, err)
+	}
+	if gid, err := set.UnmapGID(200001); err != nil || gid != 1 {
+		t.Errorf("expected gid 200001 to unmap to 1, got %d: %v", gid, err)
+	}
+	if _, err := set.UnmapUID(0); !errors.Is(err, ErrUnmappedID) {
+		t.Errorf("expected unmapped error, got: %v", err)
+	}
+
+	t.Run("nested", func(t *testing.T) {
+		// Create a user namespace with the same mappings as above which then
+		// creates another user namespace nested inside of it.
+		cmd := exec.Command("/proc/self/exe")
+		cmd.Args[0] = cmdNestedUserns
+		cmd.SysProcAttr = &syscall.SysProcAttr{
+			Cloneflags:  syscall.CLONE_NEWUSER,
+			UidMappings: uidMaps,
+			GidMappings: gidMaps,
+			Credential:  &syscall.Credential{Uid: 0, Gid: 0},
+		}
+		stdin, err := cmd.StdinPipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		stdout, err := cmd.StdoutPipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		stderr := &strings.Builder{}
+		cmd.Stderr = stderr
+		if err := cmd.Start(); err != nil {
+			t.Fatal(err)
+		}
+		defer func() {
+			stdin.Close()
+			if err := cmd.Wait(); err != nil {
+				t.Errorf("%v: %s", err, stderr)
+			}
+		}()
+
+		var pid int
+		if _, err := fmt.Fscanln(stdout, &pid); err != nil {
+			t.Fatalf("error reading pid: %v", err)
+		}
+
+		nested, err := FromPid(pid, NS_USER)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer nested.Close()
+
+		// The nested namespace maps 0-9 to 10-19 in its parent, which is
+		// then mapped to 100010-100019 on the host.
+		if uid, err := nested.MapUID(5); err != nil || uid != 100015 {
+			t.Errorf("expected nested uid 5 to map to 100015, got %d: %v", uid, err)
+		}
+		if gid, err := nested.MapGID(9); err != nil || gid != 200019 {
+			t.Errorf("expected nested gid 9 to map to 200019, got %d: %v", gid, err)
+		}
+		if uid, err := nested.UnmapUID(100010); err != nil || uid != 0 {
+			t.Errorf("expected uid 100010 to unmap to 0, got %d: %v", uid, err)
+		}
+		if _, err := nested.MapUID(10); !errors.Is(err, ErrUnmappedID) {
+			t.Errorf("expected unmapped error, got: %v", err)
+		}
+	})
+
+	if _, _, err := (Set{}).IDMaps(); err == nil {
+		t.Error("expected error for set without a user namespace")
+	}
+}
+
+const (
+	cmdNestedUserns = "nesteduserns"
+	cmdPause        = "pause"
+)
+
+// nestedUserns creates a child in a new user namespace nested in the current
+// one, prints the child's pid and waits for stdin to be closed.
+func nestedUserns() {
+	err := func() error {
+		cmd := exec.Command("/proc/self/exe")
+		cmd.Args[0] = cmdPause
+		cmd.SysProcAttr = &syscall.SysProcAttr{
+			Cloneflags:  syscall.CLONE_NEWUSER,
+			UidMappings: []syscall.SysProcIDMap{{ContainerID: 0, HostID: 10, Size: 10}},
+			GidMappings: []syscall.SysProcIDMap{{ContainerID: 0, HostID: 10, Size: 10}},
+			Credential:  &syscall.Credential{Uid: 0, Gid: 0},
+		}
+		cmd.Stdin = os.Stdin
+		cmd.Stderr = os.Stderr
+		if err := cmd.Start(); err != nil {
+			return err
+		}
+		fmt.Println(cmd.Process.Pid)
+		return cmd.Wait()
+	}()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v", err)
+		os.Exit(1)
+	}
+}
+
+// pause blocks until stdin is closed.
+func pause() {
+	io.Copy(io.Discard, os.Stdin)
+}
+
+func TestParseIDMap(t *testing.T) {
+	cases := []struct {
+		in       string
+		expected IDMap
+		valid    bool
+	}{
+		{in: "0:100000:65536", expected: IDMap{ContainerID: 0, HostID: 100000, Size: 65536}, valid: true},
+		{in: "0 100000 65536", expected: IDMap{ContainerID: 0, HostID: 100000, Size: 65536}, valid: true},
+		{in: "         0          0 65536\n", expected: IDMap{ContainerID: 0, HostID: 0, Size: 65536}, valid: true},
+		{in: "1\t1000\t1", expected: IDMap{ContainerID: 1, HostID: 1000, Size: 1}, valid: true},
+		{in: ""},
+		{in: "0:1"},
+		{in: "0:1:2:3"},
+		{in: "0 1"},
+		{in: "0:1 2"},
+		{in: "a:1:2"},
+		{in: "-1:1:2"},
+		{in: "0:4294967296:1"},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.in, func(t *testing.T) {
+			m, err := ParseIDMap(tc.in)
+			if !tc.valid {
+				if err == nil {
+					t.Fatalf("expected error, got %+v", m)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if m != tc.expected {
+				t.Errorf("expected %+v, got %+v", tc.expected, m)
+			}
+		})
+	}
+}
+
+func TestParseIDMaps(t *testing.T) {
+	expected := []IDMap{
+		{ContainerID: 0, HostID: 1000, Size: 1},
+		{ContainerID: 1, HostID: 100000, Size: 65536},
+	}
+
+	for _, in := range []string{
+		"0:1000:1,1:100000:65536",
+		"0:1000:1, 1:100000:65536,",
+		"0 1000 1\n1 100000 65536\n",
+		FormatIDMaps(expected),
+	} {
+		maps, err := ParseIDMaps(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprint(maps) != fmt.Sprint(expected) {
+			t.Errorf("%q: expected %v, got %v", in, expected, maps)
+		}
+	}
+
+	if _, err := ParseIDMaps("0:1000:1,bad"); err == nil {
+		t.Error("expected error")
+	}
+
+	if s := FormatIDMaps(expected); s != "0 1000 1\n1 100000 65536\n" {
+		t.Errorf("unexpected format: %q", s)
+	}
+}
+
+func TestPidIDMaps(t *testing.T) {
+	uidMaps, gidMaps, err := PidIDMaps(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// We should be in the initial user namespace, which has an identity mapping.
+	for _, maps := range [][]IDMap{uidMaps, gidMaps} {
+		if len(maps) != 1 || maps[0].ContainerID != 0 || maps[0].HostID != 0 {
+			t.Errorf("unexpected id maps: %v", maps)
+		}
+	}
+}
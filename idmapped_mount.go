@@ -0,0 +1,79 @@
+package gonso
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// IDMappedMountOpts configures `Set.IDMappedMount`.
+type IDMappedMountOpts struct {
+	// Recursive clones the whole mount tree found at the source, otherwise
+	// only the mount at the source is used.
+	Recursive bool
+	// ReadOnly makes the new mount read-only.
+	ReadOnly bool
+	// InNamespace performs the mount inside the set's mount namespace instead
+	// of the caller's mount namespace.
+	// The set must contain a mount namespace.
+	InNamespace bool
+}
+
+// IDMappedMount creates an idmapped bind mount of `src` at `target` using the
+// set's user namespace for the mapping.
+//
+// Files owned by an id on disk appear to be owned by that id as mapped
+// through the set's user namespace. For instance if the user namespace maps
+// 0 to 100000, a file owned by root on disk will be owned by root inside the
+// user namespace. This makes it possible to share host directories with
+// processes in the set's user namespace with the correct ownership.
+//
+// The set must contain a user namespace, and the kernel must support
+// idmapped mounts (5.12+) for the underlying filesystem.
+//
+// The source is resolved in the caller's mount namespace, `target` is
+// resolved in the set's mount namespace when `opts.InNamespace` is set and in
+// the caller's mount namespace otherwise.
+func (s Set) IDMappedMount(src, target string, opts IDMappedMountOpts) error {
+	m, err := s.IDMap(src, opts.Recursive)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	if opts.ReadOnly {
+		if err := m.SetAttr(unix.MOUNT_ATTR_RDONLY, 0, opts.Recursive); err != nil {
+			return err
+		}
+	}
+
+	if opts.InNamespace {
+		return s.MoveMount(m, target)
+	}
+	return m.MoveTo(target)
+}
+
+// IDMap creates a detached idmapped clone of the mount at `src` using the set's user namespace for the mapping.
+// See `IDMappedMount` for details.
+func (s Set) IDMap(src string, recursive bool) (*DetachedMount, error) {
+	usernsFd, ok := s.fds[unix.CLONE_NEWUSER]
+	if !ok {
+		return nil, fmt.Errorf("flag not in set for %s", nsFlagsReverse[unix.CLONE_NEWUSER])
+	}
+
+	m, err := OpenTree(src, recursive)
+	if err != nil {
+		return nil, err
+	}
+
+	attr := unix.MountAttr{
+		Attr_set:  unix.MOUNT_ATTR_IDMAP,
+		Userns_fd: uint64(usernsFd),
+	}
+	if err := m.setAttr(&attr, recursive); err != nil {
+		m.Close()
+		return nil, &os.PathError{Op: "mount_setattr", Path: src, Err: err}
+	}
+	return m, nil
+}
@@ -0,0 +1,105 @@
+package gonso
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestIDMappedMount(t *testing.T) {
+	src := t.TempDir()
+	if err := os.WriteFile(filepath.Join(src, "root"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "user"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chown(filepath.Join(src, "user"), 1000, 1000); err != nil {
+		t.Fatal(err)
+	}
+
+	maps := []IDMap{{ContainerID: 0, HostID: 100000, Size: 65536}}
+	set, err := Unshare(NS_USER|NS_MNT, WithIDMaps(maps, maps))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer set.Close()
+
+	checkOwner := func(t *testing.T, p string, uid, gid uint32) {
+		t.Helper()
+
+		fi, err := os.Stat(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		st := fi.Sys().(*syscall.Stat_t)
+		if st.Uid != uid || st.Gid != gid {
+			t.Errorf("%s: expected owner %d:%d, got %d:%d", p, uid, gid, st.Uid, st.Gid)
+		}
+	}
+
+	t.Run("caller namespace", func(t *testing.T) {
+		target := t.TempDir()
+		err := set.IDMappedMount(src, target, IDMappedMountOpts{ReadOnly: true})
+		if errors.Is(err, unix.EINVAL) || errors.Is(err, unix.ENOSYS) {
+			t.Skipf("idmapped mounts not supported: %v", err)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer unmount(target)
+
+		checkOwner(t, filepath.Join(target, "root"), 100000, 100000)
+		checkOwner(t, filepath.Join(target, "user"), 101000, 101000)
+
+		if err := os.WriteFile(filepath.Join(target, "new"), nil, 0o644); !errors.Is(err, unix.EROFS) {
+			t.Errorf("expected read-only mount, got: %v", err)
+		}
+	})
+
+	t.Run("set namespace", func(t *testing.T) {
+		target := t.TempDir()
+		err := set.IDMappedMount(src, target, IDMappedMountOpts{InNamespace: true})
+		if errors.Is(err, unix.EINVAL) || errors.Is(err, unix.ENOSYS) {
+			t.Skipf("idmapped mounts not supported: %v", err)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The mount should not be visible in the caller's mount namespace.
+		if _, err := os.Stat(filepath.Join(target, "root")); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("expected mount to not be visible outside the set: %v", err)
+		}
+
+		mnt, err := set.Dup(NS_MNT)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer mnt.Close()
+
+		err = mnt.Do(func() {
+			checkOwner(t, filepath.Join(target, "root"), 100000, 100000)
+			checkOwner(t, filepath.Join(target, "user"), 101000, 101000)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("no userns", func(t *testing.T) {
+		s, err := Unshare(NS_MNT)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer s.Close()
+
+		if err := s.IDMappedMount(src, t.TempDir(), IDMappedMountOpts{}); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+}
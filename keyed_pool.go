@@ -0,0 +1,257 @@
+package gonso
+
+import (
+	"context"
+	"sync"
+)
+
+// PoolConfig describes how sets are created for a single key in a `KeyedPool`.
+type PoolConfig struct {
+	// Flags are the namespaces to unshare for each set.
+	Flags int
+	// Opts are passed to `Unshare` when creating a set.
+	Opts []UnshareOpt
+	// AfterCreate is called in the context of each newly created set.
+	// See `NewPool` for details.
+	AfterCreate func() error
+	// Target is the number of idle sets `Run` tries to keep available for the key.
+	Target int
+}
+
+// KeyedPool manages multiple pools of Sets keyed by a configuration value.
+// This is useful when different workloads need different namespace flags or setup hooks.
+//
+// Pools for a key are created lazily the first time the key is used.
+// It is safe to use a KeyedPool from multiple goroutines.
+// Create one using `NewKeyedPool`.
+type KeyedPool[K comparable] struct {
+	mu     sync.Mutex
+	cvar   *sync.Cond
+	pools  map[K]*Pool
+	keys   []K
+	target map[K]int
+
+	config func(K) PoolConfig
+	max    int
+
+	// notify is used for testing purposes
+	// it is called when a set is created by `Run`
+	notify func()
+}
+
+// NewKeyedPool creates a new keyed pool.
+// Call `pool.Run` to start filling the pool.
+//
+// `config` is called once for each key the first time it is used and
+// determines how sets for that key are created.
+//
+// `max` is a cap on the total number of idle sets held across all keys.
+// If `max` is less than or equal to 0 there is no cap.
+func NewKeyedPool[K comparable](max int, config func(K) PoolConfig) *KeyedPool[K] {
+	p := &KeyedPool[K]{
+		pools:  make(map[K]*Pool),
+		target: make(map[K]int),
+		config: config,
+		max:    max,
+	}
+	p.cvar = sync.NewCond(&p.mu)
+	return p
+}
+
+// pool gets the pool for the given key, creating it if needed.
+// p.mu must be held.
+func (p *KeyedPool[K]) pool(key K) *Pool {
+	pool, ok := p.pools[key]
+	if ok {
+		return pool
+	}
+
+	cfg := p.config(key)
+	pool = NewPool(cfg.Flags, cfg.AfterCreate, cfg.Opts...)
+
+	p.pools[key] = pool
+	p.keys = append(p.keys, key)
+	p.target[key] = cfg.Target
+
+	// There is a new key which may need to be filled.
+	p.cvar.Signal()
+	return pool
+}
+
+// Get returns a set for the given key from the pool.
+// If there are no sets available for the key, Get will create a new one.
+func (p *KeyedPool[K]) Get(key K) (Set, error) {
+	p.mu.Lock()
+	pool := p.pool(key)
+	p.mu.Unlock()
+
+	s, err := pool.Get()
+
+	p.mu.Lock()
+	p.cvar.Signal()
+	p.mu.Unlock()
+
+	return s, err
+}
+
+// Put returns a set to the pool for the given key.
+// If the pool is already holding the maximum number of idle sets the set is closed instead.
+//
+// See `Pool.Put` for details on what state the set is expected to be in.
+func (p *KeyedPool[K]) Put(key K, s Set) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.max > 0 && p.total() >= p.max {
+		s.Close()
+		return
+	}
+	p.pool(key).Put(s)
+}
+
+// Len shows how many sets are currently in the pool for the given key.
+func (p *KeyedPool[K]) Len(key K) int {
+	p.mu.Lock()
+	pool, ok := p.pools[key]
+	p.mu.Unlock()
+
+	if !ok {
+		return 0
+	}
+	return pool.Len()
+}
+
+// Total shows how many sets are currently in the pool across all keys.
+func (p *KeyedPool[K]) Total() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.total()
+}
+
+// total requires p.mu to be held.
+func (p *KeyedPool[K]) total() int {
+	var n int
+	for _, pool := range p.pools {
+		n += pool.Len()
+	}
+	return n
+}
+
+// next returns the pool with the largest shortfall against its target and how
+// many sets to create for it.
+//
+// The count is capped so that the shortfall is brought down to that of the
+// next pool in line, this keeps filling fair across keys while still creating
+// sets in batches.
+//
+// nil is returned if there is nothing to fill or the global cap has been reached.
+//
+// p.mu must be held.
+func (p *KeyedPool[K]) next() (*Pool, int) {
+	var room int
+	if p.max > 0 {
+		room = p.max - p.total()
+		if room <= 0 {
+			return nil, 0
+		}
+	}
+
+	var (
+		next            *Pool
+		deficit, second int
+	)
+	for _, key := range p.keys {
+		pool := p.pools[key]
+		d := p.target[key] - pool.Len()
+		switch {
+		case d > deficit:
+			next = pool
+			second = deficit
+			deficit = d
+		case d > second:
+			second = d
+		}
+	}
+	if next == nil {
+		return nil, 0
+	}
+
+	n := deficit - second
+	if n < 1 {
+		n = 1
+	}
+	if room > 0 && n > room {
+		n = room
+	}
+	return next, n
+}
+
+// Run makes sure that each key used with the pool has up to its configured
+// target number of sets available, subject to the pool's global cap.
+// Run spins up a new goroutine to maintain the pool.
+// The goroutine will exit when the context is cancelled.
+//
+// The returned context will have an error set if the pool fails to create a set or is otherwise cancelled.
+func (p *KeyedPool[K]) Run(ctx context.Context) (_ context.Context, cancel func()) {
+	ctx, cancelP := newPoolContext(ctx)
+	go func() {
+		cancelP(p.run(ctx))
+	}()
+	go func() {
+		// Wake up the filler so it can see the context is done.
+		<-ctx.Done()
+		p.mu.Lock()
+		p.cvar.Broadcast()
+		p.mu.Unlock()
+	}()
+	return ctx, func() {
+		cancelP(nil)
+	}
+}
+
+func (p *KeyedPool[K]) run(ctx context.Context) error {
+	defer func() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		for _, pool := range p.pools {
+			pool.drain()
+		}
+		if p.notify != nil {
+			p.notify()
+		}
+	}()
+
+	for {
+		p.mu.Lock()
+		pool, n := p.next()
+		for pool == nil && ctx.Err() == nil {
+			p.cvar.Wait()
+			pool, n = p.next()
+		}
+		p.mu.Unlock()
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		// The lock is not held while creating sets so other keys can be used in the meantime.
+		sets, err := pool.getN(ctx, n)
+		if err != nil {
+			return err
+		}
+
+		p.mu.Lock()
+		for _, s := range sets {
+			// Sets may have been put back while these were being created.
+			if p.max > 0 && p.total() >= p.max {
+				s.Close()
+				continue
+			}
+			pool.Put(s)
+		}
+		if p.notify != nil {
+			p.notify()
+		}
+		p.mu.Unlock()
+	}
+}
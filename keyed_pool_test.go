@@ -0,0 +1,120 @@
+package gonso
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestKeyedPool(t *testing.T) {
+	type key struct {
+		flags  int
+		target int
+	}
+
+	var called int
+	p := NewKeyedPool(5, func(k key) PoolConfig {
+		return PoolConfig{
+			Flags:  k.flags,
+			Target: k.target,
+			AfterCreate: func() error {
+				called++
+				return nil
+			},
+		}
+	})
+
+	net := key{flags: NS_NET, target: 2}
+	netUTS := key{flags: NS_NET | NS_UTS, target: 4}
+
+	if p.Total() != 0 {
+		t.Fatal("expected pool to be empty")
+	}
+
+	s, err := p.Get(net)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.flags != NS_NET {
+		t.Errorf("unexpected flags for set: %d", s.flags)
+	}
+	if called != 1 {
+		t.Errorf("expected after create to be called once, got %d", called)
+	}
+	p.Put(net, s)
+
+	if p.Len(net) != 1 {
+		t.Fatal("expected pool to have one set")
+	}
+	if p.Len(netUTS) != 0 {
+		t.Fatal("expected pool for unused key to be empty")
+	}
+
+	p.notify = func() {
+		p.cvar.Broadcast()
+	}
+
+	ctx, cancelP := p.Run(context.Background())
+	defer cancelP()
+
+	// Lazily create the second pool.
+	s, err = p.Get(netUTS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.flags != NS_NET|NS_UTS {
+		t.Errorf("unexpected flags for set: %d", s.flags)
+	}
+	s.Close()
+
+	ctxT, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	waitForKeyedPool(t, ctxT, p, 5)
+
+	if n := p.Len(net); n != 2 {
+		t.Errorf("expected 2 sets for net, got %d", n)
+	}
+	if n := p.Len(netUTS); n != 3 {
+		t.Errorf("expected 3 sets for net+uts due to the global cap, got %d", n)
+	}
+
+	s, err = p.Get(net)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The pool is at capacity so this should be refilled, but the set that is
+	// put back should be dropped.
+	waitForKeyedPool(t, ctxT, p, 5)
+	p.Put(net, s)
+	if n := p.Total(); n != 5 {
+		t.Errorf("expected pool to be capped at 5, got %d", n)
+	}
+
+	cancelP()
+	<-ctx.Done()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for p.Total() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for pool to drain")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func waitForKeyedPool[K comparable](t *testing.T, ctx context.Context, p *KeyedPool[K], n int) {
+	t.Helper()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for p.total() < n {
+		select {
+		case <-ctx.Done():
+			t.Fatal(ctx.Err())
+		default:
+		}
+		p.cvar.Wait()
+	}
+}
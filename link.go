@@ -0,0 +1,212 @@
+package gonso
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+// LinkUp sets the network interface `name` in the set's network namespace up.
+// The set must contain a network namespace.
+func (s Set) LinkUp(name string) error {
+	n, err := s.Netlink()
+	if err != nil {
+		return err
+	}
+	defer n.Close()
+	return n.LinkUp(name)
+}
+
+// LinkUp sets the network interface `name` up.
+func (n *Netlink) LinkUp(name string) error {
+	if err := n.c.setLinkFlags(name, unix.IFF_UP, unix.IFF_UP); err != nil {
+		return fmt.Errorf("error setting link %s up: %w", name, err)
+	}
+	return nil
+}
+
+// LinkDown sets the network interface `name` down.
+func (n *Netlink) LinkDown(name string) error {
+	if err := n.c.setLinkFlags(name, 0, unix.IFF_UP); err != nil {
+		return fmt.Errorf("error setting link %s down: %w", name, err)
+	}
+	return nil
+}
+
+// setLinkFlags changes the flags of the link `name` which are set in `change` to the value in `flags`.
+func (c *netlinkConn) setLinkFlags(name string, flags, change uint32) error {
+	var m netlinkMsg
+	netlinkHeader(&m, &unix.IfInfomsg{Family: unix.AF_UNSPEC, Flags: flags, Change: change})
+	m.attrString(unix.IFLA_IFNAME, name)
+
+	_, err := c.execute(unix.RTM_NEWLINK, 0, m.b)
+	return err
+}
+
+// deleteLink deletes the link `name`.
+func (c *netlinkConn) deleteLink(name string) error {
+	var m netlinkMsg
+	netlinkHeader(&m, &unix.IfInfomsg{Family: unix.AF_UNSPEC})
+	m.attrString(unix.IFLA_IFNAME, name)
+
+	_, err := c.execute(unix.RTM_DELLINK, 0, m.b)
+	return err
+}
+
+// Link describes a network interface in a network namespace.
+type Link struct {
+	// Index is the interface index, which is only unique within the namespace.
+	Index int
+	// Name is the name of the interface, e.g. "eth0".
+	Name string
+	// Kind is the type of the interface, e.g. "veth" or "bridge".
+	// This is empty for interfaces without a kind, such as loopback.
+	Kind string
+	// Flags are the interface flags, e.g. `net.FlagUp`.
+	Flags net.Flags
+	// MTU is the maximum transmission unit of the interface.
+	MTU int
+	// HardwareAddr is the link layer address of the interface, if any.
+	HardwareAddr net.HardwareAddr
+	// MasterIndex is the index of the interface this one is enslaved to,
+	// e.g. a bridge, or 0 if there is none.
+	MasterIndex int
+}
+
+// LinkList returns the network interfaces in the set's network namespace.
+// The set must contain a network namespace.
+func (s Set) LinkList() ([]Link, error) {
+	n, err := s.Netlink()
+	if err != nil {
+		return nil, err
+	}
+	defer n.Close()
+	return n.LinkList()
+}
+
+// LinkList returns the network interfaces in the namespace.
+func (n *Netlink) LinkList() ([]Link, error) {
+	links, err := n.c.linkList()
+	if err != nil {
+		return nil, fmt.Errorf("error listing links: %w", err)
+	}
+	return links, nil
+}
+
+func (c *netlinkConn) linkList() ([]Link, error) {
+	var m netlinkMsg
+	netlinkHeader(&m, &unix.IfInfomsg{Family: unix.AF_UNSPEC})
+
+	msgs, err := c.execute(unix.RTM_GETLINK, unix.NLM_F_DUMP, m.b)
+	if err != nil {
+		return nil, err
+	}
+
+	links := make([]Link, 0, len(msgs))
+	for _, msg := range msgs {
+		if msg.Header.Type != unix.RTM_NEWLINK {
+			continue
+		}
+		l, err := parseLink(msg.Data)
+		if err != nil {
+			return nil, err
+		}
+		links = append(links, l)
+	}
+	return links, nil
+}
+
+// linkIndex returns the index of the link `name`.
+func (c *netlinkConn) linkIndex(name string) (int, error) {
+	l, err := c.getLink(0, name)
+	if err != nil {
+		return 0, err
+	}
+	return l.Index, nil
+}
+
+// getLink returns the link with the index `index`, or the name `name` if `index` is 0.
+func (c *netlinkConn) getLink(index int, name string) (Link, error) {
+	ref := name
+	var m netlinkMsg
+	netlinkHeader(&m, &unix.IfInfomsg{Family: unix.AF_UNSPEC, Index: int32(index)})
+	if index == 0 {
+		m.attrString(unix.IFLA_IFNAME, name)
+	} else {
+		ref = strconv.Itoa(index)
+	}
+
+	msgs, err := c.execute(unix.RTM_GETLINK, 0, m.b)
+	if err != nil {
+		return Link{}, fmt.Errorf("error getting link %s: %w", ref, err)
+	}
+	for _, msg := range msgs {
+		if msg.Header.Type == unix.RTM_NEWLINK {
+			return parseLink(msg.Data)
+		}
+	}
+	return Link{}, fmt.Errorf("error getting link %s: %w", ref, unix.ENODEV)
+}
+
+// linkNames returns a map of link index to link name.
+func (c *netlinkConn) linkNames() (map[int]string, error) {
+	links, err := c.linkList()
+	if err != nil {
+		return nil, fmt.Errorf("error listing links: %w", err)
+	}
+	names := make(map[int]string, len(links))
+	for _, l := range links {
+		names[l.Index] = l.Name
+	}
+	return names, nil
+}
+
+func parseLink(data []byte) (Link, error) {
+	info, rest, err := parseHeader[unix.IfInfomsg](data)
+	if err != nil {
+		return Link{}, err
+	}
+	attrs := parseAttrs(rest)
+
+	l := Link{
+		Index: int(info.Index),
+		Name:  attrString(attrs[unix.IFLA_IFNAME]),
+		Flags: linkFlags(info.Flags),
+	}
+	if b := attrs[unix.IFLA_MTU]; len(b) >= 4 {
+		l.MTU = int(nativeEndian.Uint32(b))
+	}
+	if b := attrs[unix.IFLA_ADDRESS]; len(b) > 0 {
+		l.HardwareAddr = append(net.HardwareAddr(nil), b...)
+	}
+	if b := attrs[unix.IFLA_MASTER]; len(b) >= 4 {
+		l.MasterIndex = int(nativeEndian.Uint32(b))
+	}
+	if b, ok := attrs[unix.IFLA_LINKINFO]; ok {
+		l.Kind = attrString(parseAttrs(b)[unix.IFLA_INFO_KIND])
+	}
+	return l, nil
+}
+
+// linkFlags converts kernel interface flags to `net.Flags`.
+func linkFlags(flags uint32) net.Flags {
+	var f net.Flags
+	if flags&unix.IFF_UP != 0 {
+		f |= net.FlagUp
+	}
+	if flags&unix.IFF_BROADCAST != 0 {
+		f |= net.FlagBroadcast
+	}
+	if flags&unix.IFF_LOOPBACK != 0 {
+		f |= net.FlagLoopback
+	}
+	if flags&unix.IFF_POINTOPOINT != 0 {
+		f |= net.FlagPointToPoint
+	}
+	if flags&unix.IFF_MULTICAST != 0 {
+		f |= net.FlagMulticast
+	}
+	return f
+}
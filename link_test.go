@@ -0,0 +1,141 @@
+package gonso
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// linkByName returns the interface `name` in the set's network namespace.
+func linkByName(t *testing.T, s Set, name string) *net.Interface {
+	t.Helper()
+
+	netns, err := s.Dup(NS_NET)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer netns.Close()
+
+	var (
+		iface    *net.Interface
+		ifaceErr error
+	)
+	if err := netns.Do(func() {
+		iface, ifaceErr = net.InterfaceByName(name)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if ifaceErr != nil {
+		t.Fatal(ifaceErr)
+	}
+	return iface
+}
+
+func TestLinkUp(t *testing.T) {
+	set, err := Unshare(NS_NET)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer set.Close()
+
+	if linkByName(t, set, "lo").Flags&net.FlagUp != 0 {
+		t.Fatal("expected loopback to start out down")
+	}
+	if err := set.LinkUp("lo"); err != nil {
+		t.Fatal(err)
+	}
+	if linkByName(t, set, "lo").Flags&net.FlagUp == 0 {
+		t.Error("expected loopback to be up")
+	}
+
+	if err := set.LinkUp("doesnotexist0"); !errors.Is(err, unix.ENODEV) {
+		t.Errorf("expected ENODEV, got: %v", err)
+	}
+}
+
+func TestWithLoopback(t *testing.T) {
+	for name, flags := range map[string]int{"netns": NS_NET, "userns": NS_NET | NS_USER} {
+		flags := flags
+		t.Run(name, func(t *testing.T) {
+			set, err := Unshare(flags, WithLoopback())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer set.Close()
+
+			if linkByName(t, set, "lo").Flags&net.FlagUp == 0 {
+				t.Error("expected loopback to be up")
+			}
+		})
+	}
+
+	t.Run("UnshareN", func(t *testing.T) {
+		sets, err := UnshareN(NS_NET, 2, WithLoopback())
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, s := range sets {
+			defer s.Close()
+			if linkByName(t, s, "lo").Flags&net.FlagUp == 0 {
+				t.Error("expected loopback to be up")
+			}
+		}
+	})
+
+	if _, err := Unshare(NS_UTS, WithLoopback()); !errors.Is(err, unix.EINVAL) {
+		t.Errorf("expected EINVAL without a network namespace, got: %v", err)
+	}
+}
+
+func TestLinkList(t *testing.T) {
+	set, err := Unshare(NS_NET, WithLoopback())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer set.Close()
+
+	if err := Veth(set, set, "veth0", "veth1", VethOpts{MTU: 1400, Up: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	links, err := set.LinkList()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	byName := make(map[string]Link)
+	for _, l := range links {
+		byName[l.Name] = l
+	}
+	if len(byName) != 3 {
+		t.Errorf("expected 3 links, got: %+v", links)
+	}
+
+	lo, ok := byName["lo"]
+	if !ok {
+		t.Fatal("missing loopback")
+	}
+	if lo.Flags&(net.FlagUp|net.FlagLoopback) != net.FlagUp|net.FlagLoopback {
+		t.Errorf("unexpected loopback flags: %v", lo.Flags)
+	}
+
+	for _, name := range []string{"veth0", "veth1"} {
+		l, ok := byName[name]
+		if !ok {
+			t.Errorf("missing %s", name)
+			continue
+		}
+		if l.Kind != "veth" {
+			t.Errorf("%s: expected kind veth, got %q", name, l.Kind)
+		}
+		if l.MTU != 1400 {
+			t.Errorf("%s: expected mtu 1400, got %d", name, l.MTU)
+		}
+		iface := linkByName(t, set, name)
+		if l.Index != iface.Index || l.HardwareAddr.String() != iface.HardwareAddr.String() {
+			t.Errorf("%s: expected %+v to match %+v", name, l, iface)
+		}
+	}
+}
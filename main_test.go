@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"runtime"
 	"testing"
 
@@ -11,11 +12,16 @@ import (
 )
 
 var cmdVtable = map[string]func(){
-	cmdReadMappings: readMappings,
+	cmdReadMappings:  readMappings,
+	cmdReadSetgroups: readSetgroups,
+	cmdNewUIDMap:     fakeNewUIDMap,
+	cmdNewGIDMap:     fakeNewGIDMap,
+	cmdNestedUserns:  nestedUserns,
+	cmdPause:         pause,
 }
 
 func TestMain(m *testing.M) {
-	if f, ok := cmdVtable[os.Args[0]]; ok {
+	if f, ok := cmdVtable[filepath.Base(os.Args[0])]; ok {
 		f()
 		return
 	}
@@ -0,0 +1,158 @@
+package gonso
+
+import (
+	"errors"
+	"os"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// MaskPaths hides the given paths in the set's mount namespace.
+// Files are masked by bind mounting /dev/null over them and directories by
+// mounting an empty, read-only, tmpfs over them.
+//
+// Paths which do not exist are ignored and paths which are already masked
+// are left alone, so it is safe to call MaskPaths multiple times with the
+// same paths.
+// The set must contain a mount namespace.
+func (s Set) MaskPaths(paths ...string) error {
+	var err error
+	if doErr := s.doMount(func() {
+		for _, p := range paths {
+			if err = maskPath(p); err != nil {
+				return
+			}
+		}
+	}); doErr != nil {
+		return doErr
+	}
+	return err
+}
+
+// ReadonlyPaths makes the given paths, and every mount below them, read-only
+// in the set's mount namespace.
+// Paths which are not already a mount point are bind mounted onto themselves
+// first so that only the given path is affected.
+//
+// Paths which do not exist are ignored and it is safe to call ReadonlyPaths
+// multiple times with the same paths.
+// The set must contain a mount namespace.
+func (s Set) ReadonlyPaths(paths ...string) error {
+	var err error
+	if doErr := s.doMount(func() {
+		for _, p := range paths {
+			if err = readonlyPath(p); err != nil {
+				return
+			}
+		}
+	}); doErr != nil {
+		return doErr
+	}
+	return err
+}
+
+func maskPath(p string) error {
+	var st unix.Statx_t
+	if err := statx(unix.AT_FDCWD, p, 0, unix.STATX_TYPE, &st); err != nil {
+		if errors.Is(err, unix.ENOENT) {
+			return nil
+		}
+		return &os.PathError{Op: "mask", Path: p, Err: err}
+	}
+
+	if st.Mode&unix.S_IFMT == unix.S_IFDIR {
+		if st.Attributes&unix.STATX_ATTR_MOUNT_ROOT != 0 {
+			masked, err := isMaskMount(p)
+			if err != nil {
+				return &os.PathError{Op: "mask", Path: p, Err: err}
+			}
+			if masked {
+				return nil
+			}
+		}
+		if err := mountFS("tmpfs", p, "tmpfs", maskFlags, maskData); err != nil {
+			return &os.PathError{Op: "mask", Path: p, Err: err}
+		}
+		return nil
+	}
+
+	if st.Attributes&unix.STATX_ATTR_MOUNT_ROOT != 0 {
+		var null unix.Statx_t
+		if err := statx(unix.AT_FDCWD, "/dev/null", 0, unix.STATX_TYPE, &null); err == nil &&
+			st.Mode&unix.S_IFMT == unix.S_IFCHR && st.Rdev_major == null.Rdev_major && st.Rdev_minor == null.Rdev_minor {
+			return nil
+		}
+	}
+	if err := mountFS("/dev/null", p, "", unix.MS_BIND, ""); err != nil {
+		return &os.PathError{Op: "mask", Path: p, Err: err}
+	}
+	return nil
+}
+
+const (
+	maskFlags = unix.MS_RDONLY | unix.MS_NOSUID | unix.MS_NODEV | unix.MS_NOEXEC
+	// size=0 would mean unlimited, keep the tmpfs as small as possible on top of it being read-only.
+	maskData = "size=4k,nr_inodes=1"
+)
+
+// isMaskMount reports whether the mount at `p` is a tmpfs mounted by
+// `maskPath`, as opposed to any other read-only tmpfs which may have content.
+func isMaskMount(p string) (bool, error) {
+	var st unix.Statx_t
+	if err := statx(unix.AT_FDCWD, p, 0, unix.STATX_MNT_ID, &st); err != nil {
+		return false, err
+	}
+
+	f, err := os.Open("/proc/thread-self/mountinfo")
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	mounts, err := ReadMountInfo(f, func(m MountInfo) bool { return uint64(m.ID) == st.Mnt_id })
+	if err != nil || len(mounts) == 0 {
+		return false, err
+	}
+	m := mounts[0]
+	if m.FSType != "tmpfs" {
+		return false, nil
+	}
+
+	has := func(opts string, want ...string) bool {
+		set := make(map[string]bool)
+		for _, o := range strings.Split(opts, ",") {
+			set[o] = true
+		}
+		for _, w := range want {
+			if !set[w] {
+				return false
+			}
+		}
+		return true
+	}
+	return has(m.Options, "ro", "nosuid", "nodev", "noexec") &&
+		has(m.SuperOptions, strings.Split(maskData, ",")...), nil
+}
+
+func readonlyPath(p string) error {
+	var st unix.Statx_t
+	if err := statx(unix.AT_FDCWD, p, 0, unix.STATX_TYPE, &st); err != nil {
+		if errors.Is(err, unix.ENOENT) {
+			return nil
+		}
+		return &os.PathError{Op: "readonly", Path: p, Err: err}
+	}
+
+	if st.Attributes&unix.STATX_ATTR_MOUNT_ROOT == 0 {
+		if err := mountFS(p, p, "", unix.MS_BIND|unix.MS_REC, ""); err != nil {
+			return &os.PathError{Op: "readonly", Path: p, Err: err}
+		}
+	}
+
+	attr := unix.MountAttr{Attr_set: unix.MOUNT_ATTR_RDONLY}
+	if err := mountSetattr(unix.AT_FDCWD, p, unix.AT_RECURSIVE, &attr); err != nil {
+		return &os.PathError{Op: "readonly", Path: p, Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package gonso
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestMaskPaths(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file")
+	masked := filepath.Join(dir, "masked")
+	ro := filepath.Join(dir, "ro")
+	for _, p := range []string{masked, ro} {
+		if err := os.Mkdir(p, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(p, "secret"), []byte("secret"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(file, []byte("secret"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	set, err := Unshare(NS_MNT, WithMountPropagation(unix.MS_PRIVATE, true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer set.Close()
+
+	countMounts := func() int {
+		var (
+			data    []byte
+			readErr error
+		)
+		if err := set.Do(func() { data, readErr = os.ReadFile("/proc/thread-self/mountinfo") }); err != nil {
+			t.Fatal(err)
+		}
+		if readErr != nil {
+			t.Fatal(readErr)
+		}
+		return bytes.Count(data, []byte("\n"))
+	}
+
+	// A read-only tmpfs with content must still be masked.
+	roTmpfs := filepath.Join(dir, "rotmpfs")
+	if err := os.Mkdir(roTmpfs, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	var mountErr error
+	err = set.doMount(func() {
+		if mountErr = mountFS("tmpfs", roTmpfs, "tmpfs", 0, "size=64k"); mountErr != nil {
+			return
+		}
+		if mountErr = os.WriteFile(filepath.Join(roTmpfs, "secret"), []byte("secret"), 0o644); mountErr != nil {
+			return
+		}
+		mountErr = mountFS("", roTmpfs, "", unix.MS_REMOUNT|unix.MS_RDONLY, "")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mountErr != nil {
+		t.Fatal(mountErr)
+	}
+
+	var n int
+	for i := 0; i < 2; i++ {
+		if err := set.MaskPaths(file, masked, roTmpfs, filepath.Join(dir, "doesnotexist")); err != nil {
+			t.Fatal(err)
+		}
+		if err := set.ReadonlyPaths(ro, filepath.Join(dir, "doesnotexist")); err != nil {
+			t.Fatal(err)
+		}
+
+		if i == 0 {
+			n = countMounts()
+		} else if count := countMounts(); count != n {
+			t.Errorf("expected repeated calls to not add mounts: %d != %d", count, n)
+		}
+	}
+
+	err = set.Do(func() {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Error(err)
+		} else if len(data) != 0 {
+			t.Errorf("expected masked file to be empty, got %q", string(data))
+		}
+
+		for _, p := range []string{masked, roTmpfs} {
+			entries, err := os.ReadDir(p)
+			if err != nil {
+				t.Error(err)
+			} else if len(entries) != 0 {
+				t.Errorf("expected masked dir %s to be empty, got %v", p, entries)
+			}
+		}
+		if err := os.WriteFile(filepath.Join(masked, "new"), nil, 0o644); !errors.Is(err, unix.EROFS) {
+			t.Errorf("expected masked dir to be read-only: %v", err)
+		}
+		var fs unix.Statfs_t
+		if err := unix.Statfs(masked, &fs); err != nil {
+			t.Error(err)
+		} else if fs.Files > 1 {
+			t.Errorf("expected masked dir to be limited to a single inode, got %d", fs.Files)
+		}
+
+		data, err = os.ReadFile(filepath.Join(ro, "secret"))
+		if err != nil {
+			t.Error(err)
+		} else if string(data) != "secret" {
+			t.Errorf("unexpected content in read-only dir: %q", string(data))
+		}
+		if err := os.WriteFile(filepath.Join(ro, "secret"), nil, 0o644); !errors.Is(err, unix.EROFS) {
+			t.Errorf("expected read-only dir: %v", err)
+		}
+
+		// Paths which were not touched should still be writable.
+		if err := os.WriteFile(filepath.Join(dir, "other"), nil, 0o644); err != nil {
+			t.Error(err)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing should have changed outside of the set.
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "secret" {
+		t.Errorf("expected file to be unchanged outside the set, got %q", string(data))
+	}
+	if err := os.WriteFile(filepath.Join(ro, "secret"), []byte("changed"), 0o644); err != nil {
+		t.Error(err)
+	}
+}
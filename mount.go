@@ -0,0 +1,200 @@
+package gonso
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// DetachedMount is a mount (or mount tree) which is not attached to any
+// mount namespace yet.
+// It is created by `OpenTree` or `NewFSMount` and can be attached in the
+// caller's mount namespace with `MoveTo` or in a set's mount namespace with
+// `Set.MoveMount`.
+//
+// A DetachedMount which is closed without ever being attached is unmounted
+// by the kernel.
+type DetachedMount struct {
+	fd   int
+	once sync.Once
+}
+
+// Fd returns the underlying file descriptor of the mount.
+// The file descriptor is owned by the DetachedMount and is closed by `Close`.
+func (m *DetachedMount) Fd() int {
+	return m.fd
+}
+
+// Close releases the file descriptor of the mount.
+// It is safe to call Close multiple times.
+func (m *DetachedMount) Close() error {
+	m.once.Do(func() {
+		sys_close(m.fd)
+	})
+	return nil
+}
+
+// SetAttr sets and clears MOUNT_ATTR_* flags on the mount.
+// If recursive is true the attributes are applied to every mount in the tree.
+func (m *DetachedMount) SetAttr(set, clear uint64, recursive bool) error {
+	if err := m.setAttr(&unix.MountAttr{Attr_set: set, Attr_clr: clear}, recursive); err != nil {
+		return fmt.Errorf("mount_setattr: %w", err)
+	}
+	return nil
+}
+
+func (m *DetachedMount) setAttr(attr *unix.MountAttr, recursive bool) error {
+	var flags uint = unix.AT_EMPTY_PATH
+	if recursive {
+		flags |= unix.AT_RECURSIVE
+	}
+	return mountSetattr(m.fd, "", flags, attr)
+}
+
+// MoveTo attaches the mount to `target` in the caller's mount namespace.
+func (m *DetachedMount) MoveTo(target string) error {
+	if err := moveMount(m.fd, "", unix.AT_FDCWD, target, unix.MOVE_MOUNT_F_EMPTY_PATH); err != nil {
+		return &os.PathError{Op: "move_mount", Path: target, Err: err}
+	}
+	return nil
+}
+
+// OpenTree creates a detached clone of the mount at `src` in the caller's mount namespace.
+// If recursive is true all mounts below `src` are cloned as well.
+//
+// This is the fd based equivalent of a (recursive) bind mount.
+func OpenTree(src string, recursive bool) (*DetachedMount, error) {
+	var flags uint = unix.OPEN_TREE_CLONE
+	if recursive {
+		flags |= unix.AT_RECURSIVE
+	}
+	fd, err := openTree(unix.AT_FDCWD, src, flags)
+	if err != nil {
+		return nil, &os.PathError{Op: "open_tree", Path: src, Err: err}
+	}
+	return &DetachedMount{fd: fd}, nil
+}
+
+// FSMountOpts configures a new filesystem created with `NewFSMount`.
+type FSMountOpts struct {
+	// Source is the source of the filesystem, e.g. a block device.
+	// Pseudo filesystems such as tmpfs or proc do not need a source.
+	Source string
+	// Options are the filesystem specific mount options in the same format
+	// used by mount(8), either "key=value" or just "key" for flags.
+	// For example "size=64m" or "mode=755" for tmpfs, "lowerdir=/a:/b" for overlay.
+	Options []string
+	// Attrs are MOUNT_ATTR_* flags to set on the new mount, e.g. unix.MOUNT_ATTR_NOSUID.
+	Attrs int
+}
+
+// NewFSMount creates a new, detached, instance of the filesystem type `fstype`.
+//
+// The filesystem is created with the namespaces of the caller. Some
+// filesystems record namespaces of the caller when they are created, see
+// `Set.NewFSMount` to create such filesystems for a set.
+func NewFSMount(fstype string, opts FSMountOpts) (*DetachedMount, error) {
+	fsfd, err := fsopen(fstype, 0)
+	if err != nil {
+		return nil, fmt.Errorf("fsopen %s: %w", fstype, err)
+	}
+	defer sys_close(fsfd)
+
+	if opts.Source != "" {
+		if err := fsconfig(fsfd, fsconfigSetString, "source", opts.Source); err != nil {
+			return nil, fmt.Errorf("error setting source for %s: %w", fstype, err)
+		}
+	}
+
+	for _, o := range opts.Options {
+		k, v, ok := strings.Cut(o, "=")
+		cmd := uint(fsconfigSetString)
+		if !ok {
+			cmd = fsconfigSetFlag
+		}
+		if err := fsconfig(fsfd, cmd, k, v); err != nil {
+			return nil, fmt.Errorf("error setting option %q for %s: %w", o, fstype, err)
+		}
+	}
+
+	if err := fsconfig(fsfd, fsconfigCmdCreate, "", ""); err != nil {
+		return nil, fmt.Errorf("error creating %s filesystem: %w", fstype, err)
+	}
+
+	fd, err := fsmount(fsfd, 0, opts.Attrs)
+	if err != nil {
+		return nil, fmt.Errorf("fsmount %s: %w", fstype, err)
+	}
+	return &DetachedMount{fd: fd}, nil
+}
+
+// OpenTree is like the package level `OpenTree` except `src` is resolved in
+// the set's mount namespace.
+// The set must contain a mount namespace.
+func (s Set) OpenTree(src string, recursive bool) (*DetachedMount, error) {
+	var (
+		m   *DetachedMount
+		err error
+	)
+	if doErr := s.doMount(func() { m, err = OpenTree(src, recursive) }); doErr != nil {
+		return nil, doErr
+	}
+	return m, err
+}
+
+// NewFSMount is like the package level `NewFSMount` except the filesystem is
+// created with the set's namespaces.
+// This matters for filesystems which capture namespaces when they are
+// created, e.g. sysfs captures the network namespace and mqueue the IPC
+// namespace.
+//
+// The user namespace of the set is not used, if the set contains one.
+// Note that proc always uses the pid namespace of the calling process rather
+// than one that is only set for children, so it does not pick up the set's
+// pid namespace.
+func (s Set) NewFSMount(fstype string, opts FSMountOpts) (*DetachedMount, error) {
+	if s.flags&^unix.CLONE_NEWUSER == 0 {
+		return NewFSMount(fstype, opts)
+	}
+
+	set, err := s.Dup(s.flags &^ unix.CLONE_NEWUSER)
+	if err != nil {
+		return nil, err
+	}
+	defer set.Close()
+
+	var m *DetachedMount
+	if doErr := set.Do(func() { m, err = NewFSMount(fstype, opts) }); doErr != nil {
+		return nil, doErr
+	}
+	return m, err
+}
+
+// MoveMount attaches the detached mount to `target` in the set's mount namespace.
+// The set must contain a mount namespace.
+//
+// The calling thread is never moved into the set's mount namespace.
+func (s Set) MoveMount(m *DetachedMount, target string) error {
+	var err error
+	if doErr := s.doMount(func() { err = m.MoveTo(target) }); doErr != nil {
+		return doErr
+	}
+	return err
+}
+
+// doMount runs f on a thread in the set's mount namespace.
+// Only the mount namespace is joined so this works even when the set contains a user namespace.
+func (s Set) doMount(f func()) error {
+	if _, ok := s.fds[unix.CLONE_NEWNS]; !ok {
+		return fmt.Errorf("flag not in set for %s", nsFlagsReverse[unix.CLONE_NEWNS])
+	}
+	mnt, err := s.Dup(unix.CLONE_NEWNS)
+	if err != nil {
+		return err
+	}
+	defer mnt.Close()
+	return mnt.Do(f)
+}
@@ -0,0 +1,185 @@
+package gonso
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestDetachedMount(t *testing.T) {
+	set, err := Unshare(NS_MNT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer set.Close()
+
+	mnt, err := set.Dup(NS_MNT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mnt.Close()
+
+	checkInSet := func(t *testing.T, p string, expected string) {
+		t.Helper()
+
+		var (
+			data []byte
+			err  error
+		)
+		if doErr := mnt.Do(func() { data, err = os.ReadFile(p) }); doErr != nil {
+			t.Fatal(doErr)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != expected {
+			t.Errorf("%s: expected %q, got %q", p, expected, string(data))
+		}
+	}
+
+	checkNotInHost := func(t *testing.T, p string) {
+		t.Helper()
+		if _, err := os.Stat(p); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("expected %s to not be visible outside the set: %v", p, err)
+		}
+	}
+
+	t.Run("open tree", func(t *testing.T) {
+		src := t.TempDir()
+		if err := os.WriteFile(filepath.Join(src, "hello"), []byte("world"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		m, err := OpenTree(src, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer m.Close()
+
+		target := t.TempDir()
+		if err := set.MoveMount(m, target); err != nil {
+			t.Fatal(err)
+		}
+		checkInSet(t, filepath.Join(target, "hello"), "world")
+		checkNotInHost(t, filepath.Join(target, "hello"))
+
+		// Clone the mount back out of the set.
+		m2, err := set.OpenTree(target, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer m2.Close()
+
+		if err := m2.SetAttr(unix.MOUNT_ATTR_RDONLY, 0, false); err != nil {
+			t.Fatal(err)
+		}
+
+		hostTarget := t.TempDir()
+		if err := m2.MoveTo(hostTarget); err != nil {
+			t.Fatal(err)
+		}
+		defer unmount(hostTarget)
+
+		data, err := os.ReadFile(filepath.Join(hostTarget, "hello"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != "world" {
+			t.Errorf("expected %q, got %q", "world", string(data))
+		}
+		if err := os.WriteFile(filepath.Join(hostTarget, "new"), nil, 0o644); !errors.Is(err, unix.EROFS) {
+			t.Errorf("expected read-only mount, got: %v", err)
+		}
+	})
+
+	t.Run("tmpfs", func(t *testing.T) {
+		m, err := set.NewFSMount("tmpfs", FSMountOpts{Options: []string{"size=1m", "mode=700"}, Attrs: unix.MOUNT_ATTR_NOSUID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer m.Close()
+
+		target := t.TempDir()
+		if err := set.MoveMount(m, target); err != nil {
+			t.Fatal(err)
+		}
+
+		err = mnt.Do(func() {
+			var st unix.Statfs_t
+			if err := unix.Statfs(target, &st); err != nil {
+				t.Error(err)
+				return
+			}
+			if st.Type != unix.TMPFS_MAGIC {
+				t.Errorf("expected tmpfs, got %x", st.Type)
+			}
+			if st.Flags&unix.ST_NOSUID == 0 {
+				t.Error("expected nosuid mount")
+			}
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("overlay", func(t *testing.T) {
+		dir := t.TempDir()
+		lower := filepath.Join(dir, "lower")
+		upper := filepath.Join(dir, "upper")
+		work := filepath.Join(dir, "work")
+		for _, p := range []string{lower, upper, work} {
+			if err := os.Mkdir(p, 0o755); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := os.WriteFile(filepath.Join(lower, "hello"), []byte("lower"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		m, err := NewFSMount("overlay", FSMountOpts{
+			Source:  "overlay",
+			Options: []string{"lowerdir=" + lower, "upperdir=" + upper, "workdir=" + work},
+		})
+		if errors.Is(err, unix.ENODEV) {
+			t.Skip("overlay not supported")
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer m.Close()
+
+		target := t.TempDir()
+		if err := set.MoveMount(m, target); err != nil {
+			t.Fatal(err)
+		}
+		checkInSet(t, filepath.Join(target, "hello"), "lower")
+		checkNotInHost(t, filepath.Join(target, "hello"))
+	})
+
+	t.Run("bad option", func(t *testing.T) {
+		if _, err := NewFSMount("tmpfs", FSMountOpts{Options: []string{"notanoption"}}); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+
+	t.Run("no mount namespace", func(t *testing.T) {
+		s, err := Unshare(NS_NET)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer s.Close()
+
+		m, err := OpenTree(t.TempDir(), false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer m.Close()
+
+		if err := s.MoveMount(m, t.TempDir()); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+}
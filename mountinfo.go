@@ -0,0 +1,245 @@
+package gonso
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// MountInfo is a single entry from /proc/<pid>/mountinfo.
+// See proc(5) for details on each field.
+type MountInfo struct {
+	// ID is the unique id of the mount.
+	ID int
+	// Parent is the id of the parent mount.
+	Parent int
+	// Major is the major device number of the filesystem.
+	Major int
+	// Minor is the minor device number of the filesystem.
+	Minor int
+	// Root is the path within the filesystem which is the root of the mount.
+	Root string
+	// Mountpoint is where the filesystem is mounted, relative to the root of the reading process.
+	Mountpoint string
+	// Options are the per-mount options, e.g. "rw,nosuid".
+	Options string
+	// Optional are the optional fields such as propagation tags, e.g. "shared:1" or "master:2".
+	Optional []string
+	// FSType is the filesystem type, e.g. "ext4".
+	FSType string
+	// Source is the filesystem specific source, e.g. a block device.
+	Source string
+	// SuperOptions are the per-superblock options.
+	SuperOptions string
+}
+
+// optional returns the value of the optional field with the given tag, e.g. "shared".
+func (m MountInfo) optional(tag string) (int, bool) {
+	for _, f := range m.Optional {
+		k, v, ok := strings.Cut(f, ":")
+		if !ok || k != tag {
+			continue
+		}
+		id, err := strconv.Atoi(v)
+		return id, err == nil
+	}
+	return 0, false
+}
+
+// Shared returns the peer group of the mount if it is a shared mount.
+func (m MountInfo) Shared() (int, bool) {
+	return m.optional("shared")
+}
+
+// Master returns the peer group the mount receives propagation from if it is a slave mount.
+func (m MountInfo) Master() (int, bool) {
+	return m.optional("master")
+}
+
+// Unbindable reports whether the mount is unbindable.
+func (m MountInfo) Unbindable() bool {
+	for _, f := range m.Optional {
+		if f == "unbindable" {
+			return true
+		}
+	}
+	return false
+}
+
+// MountFilter is used to select entries when reading mountinfo.
+// Entries for which the filter returns false are dropped.
+type MountFilter func(MountInfo) bool
+
+// FSTypeFilter selects mounts with any of the given filesystem types.
+func FSTypeFilter(types ...string) MountFilter {
+	return func(m MountInfo) bool {
+		for _, t := range types {
+			if m.FSType == t {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// MountpointFilter selects the mount at `p`.
+// If a path has multiple mounts stacked on top of each other, all of them are selected.
+func MountpointFilter(p string) MountFilter {
+	return func(m MountInfo) bool {
+		return m.Mountpoint == p
+	}
+}
+
+// PrefixFilter selects mounts at or below `prefix`.
+func PrefixFilter(prefix string) MountFilter {
+	prefix = strings.TrimSuffix(prefix, "/")
+	return func(m MountInfo) bool {
+		return prefix == "" || m.Mountpoint == prefix || strings.HasPrefix(m.Mountpoint, prefix+"/")
+	}
+}
+
+// SourceFilter selects mounts with the given source.
+func SourceFilter(source string) MountFilter {
+	return func(m MountInfo) bool {
+		return m.Source == source
+	}
+}
+
+// Mounts returns the mounts in the set's mount namespace, in the order they
+// appear in mountinfo.
+// Only mounts which are accepted by all the filters are returned.
+// The set must contain a mount namespace.
+func (s Set) Mounts(filters ...MountFilter) ([]MountInfo, error) {
+	var (
+		data []byte
+		err  error
+	)
+	if doErr := s.doMount(func() { data, err = os.ReadFile("/proc/thread-self/mountinfo") }); doErr != nil {
+		return nil, doErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ReadMountInfo(bytes.NewReader(data), filters...)
+}
+
+// PidMounts returns the mounts as seen by the process with the given pid.
+// See `Set.Mounts`.
+func PidMounts(pid int, filters ...MountFilter) ([]MountInfo, error) {
+	f, err := os.Open("/proc/" + strconv.Itoa(pid) + "/mountinfo")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadMountInfo(f, filters...)
+}
+
+// ReadMountInfo parses mountinfo entries, in the format of /proc/<pid>/mountinfo, from `r`.
+// Only entries which are accepted by all the filters are returned.
+func ReadMountInfo(r io.Reader, filters ...MountFilter) ([]MountInfo, error) {
+	var mounts []MountInfo
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+		m, err := parseMountInfo(line)
+		if err != nil {
+			return nil, err
+		}
+
+		keep := true
+		for _, f := range filters {
+			if !f(m) {
+				keep = false
+				break
+			}
+		}
+		if keep {
+			mounts = append(mounts, m)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading mountinfo: %w", err)
+	}
+	return mounts, nil
+}
+
+func parseMountInfo(line string) (MountInfo, error) {
+	// 36 35 98:0 /mnt1 /mnt2 rw,noatime master:1 - ext3 /dev/root rw,errors=continue
+	fields := strings.Fields(line)
+	sep := -1
+	for i := 6; i < len(fields); i++ {
+		if fields[i] == "-" {
+			sep = i
+			break
+		}
+	}
+	if sep < 0 || len(fields) < sep+3 {
+		return MountInfo{}, fmt.Errorf("invalid mountinfo entry: %q", line)
+	}
+
+	var (
+		m   MountInfo
+		err error
+	)
+	if m.ID, err = strconv.Atoi(fields[0]); err != nil {
+		return MountInfo{}, fmt.Errorf("invalid mount id in mountinfo entry %q: %w", line, err)
+	}
+	if m.Parent, err = strconv.Atoi(fields[1]); err != nil {
+		return MountInfo{}, fmt.Errorf("invalid parent id in mountinfo entry %q: %w", line, err)
+	}
+
+	major, minor, ok := strings.Cut(fields[2], ":")
+	if !ok {
+		return MountInfo{}, fmt.Errorf("invalid device in mountinfo entry: %q", line)
+	}
+	if m.Major, err = strconv.Atoi(major); err != nil {
+		return MountInfo{}, fmt.Errorf("invalid major number in mountinfo entry %q: %w", line, err)
+	}
+	if m.Minor, err = strconv.Atoi(minor); err != nil {
+		return MountInfo{}, fmt.Errorf("invalid minor number in mountinfo entry %q: %w", line, err)
+	}
+
+	m.Root = unescapeMountinfo(fields[3])
+	m.Mountpoint = unescapeMountinfo(fields[4])
+	m.Options = fields[5]
+	if sep > 6 {
+		m.Optional = fields[6:sep]
+	}
+	m.FSType = unescapeMountinfo(fields[sep+1])
+	m.Source = unescapeMountinfo(fields[sep+2])
+	if len(fields) > sep+3 {
+		m.SuperOptions = fields[sep+3]
+	}
+	return m, nil
+}
+
+// unescapeMountinfo decodes the octal escapes (e.g. "\040" for a space) the kernel uses in mountinfo.
+func unescapeMountinfo(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) && isOctal(s[i+1]) && isOctal(s[i+2]) && isOctal(s[i+3]) {
+			b.WriteByte((s[i+1]-'0')<<6 | (s[i+2]-'0')<<3 | (s[i+3] - '0'))
+			i += 3
+			continue
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+func isOctal(c byte) bool {
+	return c >= '0' && c <= '7'
+}
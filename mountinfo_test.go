@@ -0,0 +1,121 @@
+package gonso
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestReadMountInfo(t *testing.T) {
+	const data = `36 35 98:0 /mnt1 /mnt2 rw,noatime master:1 - ext3 /dev/root rw,errors=continue
+22 1 0:21 / /proc rw,nosuid,nodev,noexec,relatime shared:12 - proc proc rw
+40 22 0:35 / /with\040space rw shared:3 master:2 - tmpfs tmpfs rw,size=1024k
+41 22 0:36 / /unbindable rw unbindable - tmpfs none rw
+`
+
+	mounts, err := ReadMountInfo(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []MountInfo{
+		{ID: 36, Parent: 35, Major: 98, Minor: 0, Root: "/mnt1", Mountpoint: "/mnt2", Options: "rw,noatime", Optional: []string{"master:1"}, FSType: "ext3", Source: "/dev/root", SuperOptions: "rw,errors=continue"},
+		{ID: 22, Parent: 1, Major: 0, Minor: 21, Root: "/", Mountpoint: "/proc", Options: "rw,nosuid,nodev,noexec,relatime", Optional: []string{"shared:12"}, FSType: "proc", Source: "proc", SuperOptions: "rw"},
+		{ID: 40, Parent: 22, Major: 0, Minor: 35, Root: "/", Mountpoint: "/with space", Options: "rw", Optional: []string{"shared:3", "master:2"}, FSType: "tmpfs", Source: "tmpfs", SuperOptions: "rw,size=1024k"},
+		{ID: 41, Parent: 22, Major: 0, Minor: 36, Root: "/", Mountpoint: "/unbindable", Options: "rw", Optional: []string{"unbindable"}, FSType: "tmpfs", Source: "none", SuperOptions: "rw"},
+	}
+	if !reflect.DeepEqual(mounts, expected) {
+		t.Fatalf("expected:\n%+v\ngot:\n%+v", expected, mounts)
+	}
+
+	if _, ok := mounts[0].Shared(); ok {
+		t.Error("expected mount to not be shared")
+	}
+	if id, ok := mounts[0].Master(); !ok || id != 1 {
+		t.Errorf("expected master 1, got %d", id)
+	}
+	if id, ok := mounts[2].Shared(); !ok || id != 3 {
+		t.Errorf("expected peer group 3, got %d", id)
+	}
+	if !mounts[3].Unbindable() || mounts[2].Unbindable() {
+		t.Error("unexpected unbindable state")
+	}
+
+	filtered, err := ReadMountInfo(strings.NewReader(data), FSTypeFilter("tmpfs", "proc"), PrefixFilter("/with space"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(filtered) != 1 || filtered[0].ID != 40 {
+		t.Errorf("unexpected filtered mounts: %+v", filtered)
+	}
+
+	if _, err := ReadMountInfo(strings.NewReader("36 35 98:0 /mnt1 /mnt2 rw,noatime\n")); err == nil {
+		t.Error("expected error for invalid entry")
+	}
+}
+
+func TestSetMounts(t *testing.T) {
+	set, err := Unshare(NS_MNT, WithMountPropagation(unix.MS_PRIVATE, true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer set.Close()
+
+	target := filepath.Join(t.TempDir(), "with space")
+	if err := os.Mkdir(target, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := NewFSMount("tmpfs", FSMountOpts{Source: "gonso-test", Options: []string{"size=1m"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	if err := set.MoveMount(m, target); err != nil {
+		t.Fatal(err)
+	}
+
+	mounts, err := set.Mounts(MountpointFilter(target))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mounts) != 1 {
+		t.Fatalf("expected 1 mount, got %+v", mounts)
+	}
+	if mounts[0].FSType != "tmpfs" || mounts[0].Source != "gonso-test" {
+		t.Errorf("unexpected mount: %+v", mounts[0])
+	}
+	if _, ok := mounts[0].Shared(); ok {
+		t.Errorf("expected private mount: %+v", mounts[0])
+	}
+
+	mounts, err = set.Mounts(SourceFilter("gonso-test"), FSTypeFilter("tmpfs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mounts) != 1 {
+		t.Errorf("expected 1 mount, got %+v", mounts)
+	}
+
+	// The mount should not be visible outside of the set.
+	mounts, err = PidMounts(os.Getpid(), MountpointFilter(target))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mounts) != 0 {
+		t.Errorf("expected no mounts outside the set, got %+v", mounts)
+	}
+
+	s, err := Unshare(NS_NET)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if _, err := s.Mounts(); err == nil {
+		t.Error("expected error without a mount namespace")
+	}
+}
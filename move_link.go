@@ -0,0 +1,135 @@
+package gonso
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// MoveLinkOpts configures `Set.MoveLink` and friends.
+type MoveLinkOpts struct {
+	// Name, when set, renames the link once it has been moved.
+	Name string
+	// Up sets the link up once it has been moved.
+	// Moving a link to another network namespace always sets it down.
+	Up bool
+}
+
+// MoveLink moves the network interface named `link` from the network
+// namespace of `from` into the set's network namespace.
+// Both sets must contain a network namespace.
+//
+// The link keeps its name unless `opts.Name` is set, in which case it is
+// renamed after it has been moved. If renaming or setting the link up fails
+// the link is moved back to `from`.
+//
+// The returned Link describes the link as it is in the set's network namespace,
+// note that its index may differ from the index it had in `from`.
+//
+// Use `Set.ReturnLink` to move the link back out of the set, e.g. for teardown.
+func (s Set) MoveLink(link string, from Set, opts MoveLinkOpts) (Link, error) {
+	return moveLink(from, s, 0, link, opts)
+}
+
+// MoveLinkIndex is the same as `MoveLink` except the link is identified by its
+// index in the network namespace of `from`.
+func (s Set) MoveLinkIndex(index int, from Set, opts MoveLinkOpts) (Link, error) {
+	if index <= 0 {
+		return Link{}, fmt.Errorf("invalid link index %d: %w", index, unix.EINVAL)
+	}
+	return moveLink(from, s, index, "", opts)
+}
+
+// ReturnLink is the reverse of `MoveLink`, it moves the network interface
+// named `link` from the set's network namespace to the network namespace of `to`.
+// `opts.Name` can be used to restore the original name of a link which was
+// renamed by `MoveLink`.
+func (s Set) ReturnLink(link string, to Set, opts MoveLinkOpts) (Link, error) {
+	return moveLink(s, to, 0, link, opts)
+}
+
+func moveLink(from, to Set, index int, name string, opts MoveLinkOpts) (_ Link, retErr error) {
+	fromFd, ok := from.fds[unix.CLONE_NEWNET]
+	if !ok {
+		return Link{}, fmt.Errorf("flag not in source set for %s", nsFlagsReverse[unix.CLONE_NEWNET])
+	}
+	toFd, ok := to.fds[unix.CLONE_NEWNET]
+	if !ok {
+		return Link{}, fmt.Errorf("flag not in target set for %s", nsFlagsReverse[unix.CLONE_NEWNET])
+	}
+
+	fromConn, err := from.netlink()
+	if err != nil {
+		return Link{}, err
+	}
+	defer fromConn.Close()
+
+	toConn, err := to.netlink()
+	if err != nil {
+		return Link{}, err
+	}
+	defer toConn.Close()
+
+	l, err := fromConn.getLink(index, name)
+	if err != nil {
+		return Link{}, err
+	}
+
+	if err := fromConn.setLinkNetns(l.Index, toFd); err != nil {
+		return Link{}, fmt.Errorf("error moving link %s: %w", l.Name, err)
+	}
+
+	// Without a name pattern the kernel refuses the move rather than renaming
+	// the link, so it must have kept its name in the new namespace.
+	moved, err := toConn.getLink(0, l.Name)
+	if err != nil {
+		return Link{}, fmt.Errorf("error verifying link %s was moved: %w", l.Name, err)
+	}
+
+	defer func() {
+		if retErr != nil {
+			// Put the original name back so the link is returned as it was found.
+			if moved.Name != l.Name {
+				toConn.renameLink(moved.Index, l.Name)
+			}
+			toConn.setLinkNetns(moved.Index, fromFd)
+		}
+	}()
+
+	if opts.Name != "" && opts.Name != moved.Name {
+		if err := toConn.renameLink(moved.Index, opts.Name); err != nil {
+			return Link{}, fmt.Errorf("error renaming link %s to %s: %w", moved.Name, opts.Name, err)
+		}
+		moved.Name = opts.Name
+	}
+
+	if opts.Up {
+		var m netlinkMsg
+		netlinkHeader(&m, &unix.IfInfomsg{Family: unix.AF_UNSPEC, Index: int32(moved.Index), Flags: unix.IFF_UP, Change: unix.IFF_UP})
+		if _, err := toConn.execute(unix.RTM_NEWLINK, 0, m.b); err != nil {
+			return Link{}, fmt.Errorf("error setting link %s up: %w", moved.Name, err)
+		}
+	}
+
+	return toConn.getLink(moved.Index, "")
+}
+
+// renameLink renames the link with index `index` to `name`.
+func (c *netlinkConn) renameLink(index int, name string) error {
+	var m netlinkMsg
+	netlinkHeader(&m, &unix.IfInfomsg{Family: unix.AF_UNSPEC, Index: int32(index)})
+	m.attrString(unix.IFLA_IFNAME, name)
+
+	_, err := c.execute(unix.RTM_NEWLINK, 0, m.b)
+	return err
+}
+
+// setLinkNetns moves the link with index `index` to the network namespace referred to by `nsFd`.
+func (c *netlinkConn) setLinkNetns(index, nsFd int) error {
+	var m netlinkMsg
+	netlinkHeader(&m, &unix.IfInfomsg{Family: unix.AF_UNSPEC, Index: int32(index)})
+	m.attrU32(unix.IFLA_NET_NS_FD, uint32(nsFd))
+
+	_, err := c.execute(unix.RTM_NEWLINK, 0, m.b)
+	return err
+}
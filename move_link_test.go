@@ -0,0 +1,152 @@
+package gonso
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestMoveLink(t *testing.T) {
+	sets, err := UnshareN(NS_NET, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, b := sets[0], sets[1]
+	defer a.Close()
+	defer b.Close()
+
+	if err := Veth(a, a, "veth0", "veth1", VethOpts{Up: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	hasLink := func(s Set, name string) bool {
+		t.Helper()
+		links, err := s.LinkList()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, l := range links {
+			if l.Name == name {
+				return true
+			}
+		}
+		return false
+	}
+
+	l, err := b.MoveLink("veth0", a, MoveLinkOpts{Name: "eth0", Up: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.Name != "eth0" || l.Kind != "veth" {
+		t.Errorf("unexpected link: %+v", l)
+	}
+	if l.Flags&net.FlagUp == 0 {
+		t.Error("expected link to be up")
+	}
+	if iface := linkByName(t, b, "eth0"); iface.Index != l.Index {
+		t.Errorf("expected index %d, got %d", l.Index, iface.Index)
+	}
+	if hasLink(a, "veth0") {
+		t.Error("expected veth0 to be gone from the source")
+	}
+
+	l, err = b.ReturnLink("eth0", a, MoveLinkOpts{Name: "veth0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.Name != "veth0" {
+		t.Errorf("expected link to be renamed back, got: %+v", l)
+	}
+	if hasLink(b, "eth0") {
+		t.Error("expected eth0 to be gone from the set")
+	}
+
+	peer := linkByName(t, a, "veth1")
+	l, err = b.MoveLinkIndex(peer.Index, a, MoveLinkOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.Name != "veth1" {
+		t.Errorf("expected name to be kept, got: %+v", l)
+	}
+	if l.Flags&net.FlagUp != 0 {
+		t.Error("expected link to be down")
+	}
+
+	// veth1 is already taken in b.
+	if err := Veth(a, a, "veth1", "veth2", VethOpts{Up: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.MoveLink("veth1", a, MoveLinkOpts{}); !errors.Is(err, unix.EEXIST) {
+		t.Errorf("expected EEXIST, got: %v", err)
+	}
+	if !hasLink(a, "veth1") {
+		t.Error("expected veth1 to stay in the source")
+	}
+
+	// Renaming to a taken name moves the link back.
+	if _, err := b.MoveLink("veth2", a, MoveLinkOpts{Name: "veth1"}); !errors.Is(err, unix.EEXIST) {
+		t.Errorf("expected EEXIST, got: %v", err)
+	}
+	if !hasLink(a, "veth2") || hasLink(b, "veth2") {
+		t.Error("expected veth2 to be moved back to the source")
+	}
+
+	if _, err := b.MoveLink("doesnotexist0", a, MoveLinkOpts{}); !errors.Is(err, unix.ENODEV) {
+		t.Errorf("expected ENODEV, got: %v", err)
+	}
+
+	// A vxlan opens its UDP socket in the namespace it was created in when it
+	// is set up, so with the port taken in the source the link is renamed and
+	// then fails to come up. It should be returned to the source with its
+	// original name.
+	netns, err := a.Dup(NS_NET)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer netns.Close()
+	var pc net.PacketConn
+	var listenErr error
+	if err := netns.Do(func() { pc, listenErr = net.ListenPacket("udp4", "0.0.0.0:0") }); err != nil {
+		t.Fatal(err)
+	}
+	if listenErr != nil {
+		t.Fatal(listenErr)
+	}
+	defer pc.Close()
+
+	conn, err := a.netlink()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	port := make([]byte, 2)
+	binary.BigEndian.PutUint16(port, uint16(pc.LocalAddr().(*net.UDPAddr).Port))
+	var m netlinkMsg
+	netlinkHeader(&m, &unix.IfInfomsg{Family: unix.AF_UNSPEC})
+	m.attrString(unix.IFLA_IFNAME, "vxlan0")
+	m.nest(unix.IFLA_LINKINFO, func() {
+		m.attrString(unix.IFLA_INFO_KIND, "vxlan")
+		m.nest(unix.IFLA_INFO_DATA, func() {
+			m.attrU32(unix.IFLA_VXLAN_ID, 10)
+			m.attr(unix.IFLA_VXLAN_PORT, port)
+		})
+	})
+	if _, err := conn.execute(unix.RTM_NEWLINK, unix.NLM_F_CREATE|unix.NLM_F_EXCL, m.b); err != nil {
+		t.Skipf("vxlan not supported: %v", err)
+	}
+
+	if _, err := b.MoveLink("vxlan0", a, MoveLinkOpts{Name: "eth1", Up: true}); !errors.Is(err, unix.EADDRINUSE) {
+		t.Errorf("expected EADDRINUSE, got: %v", err)
+	}
+	if !hasLink(a, "vxlan0") {
+		t.Error("expected vxlan0 to be moved back to the source with its original name")
+	}
+	if hasLink(a, "eth1") || hasLink(b, "eth1") || hasLink(b, "vxlan0") {
+		t.Error("expected the renamed link to be gone")
+	}
+}
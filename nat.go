@@ -0,0 +1,395 @@
+package gonso
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net/netip"
+
+	"golang.org/x/sys/unix"
+)
+
+// DefaultNATTable is the nftables table used by `Set.ApplyNAT` when no table is specified.
+const DefaultNATTable = "gonso"
+
+// NATConfig describes the NAT rules installed by `Set.ApplyNAT`.
+type NATConfig struct {
+	// Table is the name of the nftables table (in the inet family) holding the rules.
+	// The table is owned by gonso, anything else in it is removed.
+	// If this is empty `DefaultNATTable` is used.
+	Table string
+	// Masquerade is a list of subnets, e.g. the subnet of a `Bridge`, whose
+	// traffic is masqueraded when it leaves the subnet.
+	Masquerade []netip.Prefix
+	// PortMaps are ports published on the local addresses of the namespace.
+	PortMaps []PortMap
+}
+
+// PortMap forwards connections to a port on any local address of a network
+// namespace to an address in another network namespace, e.g. a sandbox.
+type PortMap struct {
+	// Protocol is either "tcp" or "udp".
+	Protocol string
+	// HostPort is the published port.
+	HostPort uint16
+	// Addr is the address connections are forwarded to.
+	Addr netip.Addr
+	// Port is the port connections are forwarded to.
+	// If this is 0 `HostPort` is used.
+	Port uint16
+}
+
+// nftables chain names and priorities (NF_IP_PRI_NAT_DST/NF_IP_PRI_NAT_SRC).
+const (
+	natChainPrerouting  = "prerouting"
+	natChainOutput      = "output"
+	natChainPostrouting = "postrouting"
+	natChainPortMaps    = "portmaps"
+
+	natPriorityDst = -100
+	natPrioritySrc = 100
+)
+
+// ApplyNAT installs the masquerade and port forwarding rules described by
+// `cfg` in the set's network namespace using nftables.
+// The set must contain a network namespace.
+//
+// The rules live in a dedicated table which is replaced as a whole, in a
+// single nftables transaction, each time ApplyNAT is called. Either all the
+// rules are applied or none are, and the previous rules stay in place if
+// applying fails.
+//
+// Port maps apply to traffic to local addresses of the namespace coming in
+// from outside as well as to connections made from inside the namespace.
+// Note that forwarding (e.g. net.ipv4.ip_forward) must be enabled in the
+// namespace for masqueraded or forwarded traffic to be routed.
+//
+// Use `Set.RemoveNAT` to remove the rules.
+func (s Set) ApplyNAT(cfg NATConfig) error {
+	table := cfg.Table
+	if table == "" {
+		table = DefaultNATTable
+	}
+
+	reqs := nftReplaceTable(table)
+	for _, c := range []struct {
+		name     string
+		hook     uint32
+		priority int32
+	}{
+		{natChainPrerouting, unix.NF_INET_PRE_ROUTING, natPriorityDst},
+		{natChainOutput, unix.NF_INET_LOCAL_OUT, natPriorityDst},
+		{natChainPostrouting, unix.NF_INET_POST_ROUTING, natPrioritySrc},
+	} {
+		reqs = append(reqs, nftNewChain(table, c.name, func(m *netlinkMsg) {
+			m.nest(unix.NFTA_CHAIN_HOOK|unix.NLA_F_NESTED, func() {
+				m.attrBE32(unix.NFTA_HOOK_HOOKNUM, c.hook)
+				m.attrBE32(unix.NFTA_HOOK_PRIORITY, uint32(c.priority))
+			})
+			m.attrString(unix.NFTA_CHAIN_TYPE, "nat")
+		}))
+	}
+	reqs = append(reqs, nftNewChain(table, natChainPortMaps, nil))
+
+	// fib daddr type local jump portmaps
+	for _, chain := range []string{natChainPrerouting, natChainOutput} {
+		reqs = append(reqs, nftNewRule(table, chain, func(e *nftExprs) {
+			e.fibDaddrType()
+			e.cmp(unix.NFT_CMP_EQ, nativeU32(unix.RTN_LOCAL))
+			e.jump(natChainPortMaps)
+		}))
+	}
+
+	for _, p := range cfg.Masquerade {
+		if !p.IsValid() {
+			return fmt.Errorf("invalid masquerade subnet %s: %w", p, unix.EINVAL)
+		}
+		p = netip.PrefixFrom(p.Addr().Unmap(), p.Bits()).Masked()
+		// ip saddr <p> ip daddr != <p> masquerade
+		reqs = append(reqs, nftNewRule(table, natChainPostrouting, func(e *nftExprs) {
+			e.nfproto(p.Addr())
+			e.prefix(ipSrcOffset(p.Addr()), p, unix.NFT_CMP_EQ)
+			e.prefix(ipDstOffset(p.Addr()), p, unix.NFT_CMP_NEQ)
+			e.expr("masq", nil)
+		}))
+	}
+
+	for _, pm := range cfg.PortMaps {
+		var proto byte
+		switch pm.Protocol {
+		case "tcp":
+			proto = unix.IPPROTO_TCP
+		case "udp":
+			proto = unix.IPPROTO_UDP
+		default:
+			return fmt.Errorf("invalid port map protocol %q: %w", pm.Protocol, unix.EINVAL)
+		}
+		if pm.HostPort == 0 || !pm.Addr.IsValid() {
+			return fmt.Errorf("port map needs a host port and address: %w", unix.EINVAL)
+		}
+		addr := pm.Addr.Unmap()
+		port := pm.Port
+		if port == 0 {
+			port = pm.HostPort
+		}
+
+		// meta l4proto <proto> th dport <host port> dnat to <addr>:<port>
+		reqs = append(reqs, nftNewRule(table, natChainPortMaps, func(e *nftExprs) {
+			e.nfproto(addr)
+			e.meta(unix.NFT_META_L4PROTO)
+			e.cmp(unix.NFT_CMP_EQ, []byte{proto})
+			e.payload(unix.NFT_PAYLOAD_TRANSPORT_HEADER, 2, 2)
+			e.cmp(unix.NFT_CMP_EQ, be16(pm.HostPort))
+			e.dnat(addr, port)
+		}))
+	}
+
+	return s.nftCommit(reqs)
+}
+
+// RemoveNAT removes the nftables table `table` created by `Set.ApplyNAT`,
+// along with all of its rules, from the set's network namespace.
+// If `table` is empty `DefaultNATTable` is used.
+// It is not an error if the table does not exist.
+// The set must contain a network namespace.
+func (s Set) RemoveNAT(table string) error {
+	if table == "" {
+		table = DefaultNATTable
+	}
+	reqs := nftReplaceTable(table)
+	// Drop the re-creation of the table.
+	return s.nftCommit(reqs[:len(reqs)-1])
+}
+
+// nftCommit sends `reqs` to nf_tables as a single transaction.
+func (s Set) nftCommit(reqs []netlinkRequest) error {
+	c, err := s.netlinkProto(unix.NETLINK_NETFILTER)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	batch := make([]netlinkRequest, 0, len(reqs)+2)
+	batch = append(batch, nftBatchMsg(unix.NFNL_MSG_BATCH_BEGIN))
+	batch = append(batch, reqs...)
+	batch = append(batch, nftBatchMsg(unix.NFNL_MSG_BATCH_END))
+
+	if err := c.executeBatch(batch); err != nil {
+		return fmt.Errorf("error applying nftables rules: %w", err)
+	}
+	return nil
+}
+
+func nftBatchMsg(typ uint16) netlinkRequest {
+	// Batch messages carry the subsystem they are for in res_id.
+	var b [4]byte
+	b[1] = unix.NFNETLINK_V0
+	binary.BigEndian.PutUint16(b[2:], unix.NFNL_SUBSYS_NFTABLES)
+	return netlinkRequest{typ: typ, payload: b[:]}
+}
+
+// nftMsg starts an nf_tables message for the inet family.
+func nftMsg() *netlinkMsg {
+	var m netlinkMsg
+	m.b = append(m.b, unix.NFPROTO_INET, unix.NFNETLINK_V0, 0, 0)
+	return &m
+}
+
+func nftRequest(typ uint16, flags uint16, m *netlinkMsg) netlinkRequest {
+	return netlinkRequest{
+		typ:     unix.NFNL_SUBSYS_NFTABLES<<8 | typ,
+		flags:   flags | unix.NLM_F_ACK,
+		payload: m.b,
+	}
+}
+
+// nftReplaceTable returns the messages which (re)create `table` empty.
+// Creating the table first makes deleting it succeed even if it does not exist yet.
+func nftReplaceTable(table string) []netlinkRequest {
+	var reqs []netlinkRequest
+	for _, r := range []struct {
+		typ   uint16
+		flags uint16
+	}{
+		{unix.NFT_MSG_NEWTABLE, unix.NLM_F_CREATE},
+		{unix.NFT_MSG_DELTABLE, 0},
+		{unix.NFT_MSG_NEWTABLE, unix.NLM_F_CREATE | unix.NLM_F_EXCL},
+	} {
+		m := nftMsg()
+		m.attrString(unix.NFTA_TABLE_NAME, table)
+		reqs = append(reqs, nftRequest(r.typ, r.flags, m))
+	}
+	return reqs
+}
+
+func nftNewChain(table, chain string, f func(*netlinkMsg)) netlinkRequest {
+	m := nftMsg()
+	m.attrString(unix.NFTA_CHAIN_TABLE, table)
+	m.attrString(unix.NFTA_CHAIN_NAME, chain)
+	if f != nil {
+		f(m)
+	}
+	return nftRequest(unix.NFT_MSG_NEWCHAIN, unix.NLM_F_CREATE|unix.NLM_F_EXCL, m)
+}
+
+func nftNewRule(table, chain string, f func(*nftExprs)) netlinkRequest {
+	m := nftMsg()
+	m.attrString(unix.NFTA_RULE_TABLE, table)
+	m.attrString(unix.NFTA_RULE_CHAIN, chain)
+	m.nest(unix.NFTA_RULE_EXPRESSIONS|unix.NLA_F_NESTED, func() {
+		f(&nftExprs{m: m})
+	})
+	return nftRequest(unix.NFT_MSG_NEWRULE, unix.NLM_F_CREATE|unix.NLM_F_APPEND, m)
+}
+
+// nftExprs builds the list of expressions of an nf_tables rule.
+// All expressions load into and compare against NFT_REG_1 unless noted otherwise.
+type nftExprs struct {
+	m *netlinkMsg
+}
+
+func (e *nftExprs) expr(name string, data func()) {
+	e.m.nest(unix.NFTA_LIST_ELEM|unix.NLA_F_NESTED, func() {
+		e.m.attrString(unix.NFTA_EXPR_NAME, name)
+		if data != nil {
+			e.m.nest(unix.NFTA_EXPR_DATA|unix.NLA_F_NESTED, data)
+		}
+	})
+}
+
+// data adds an nft_data value attribute.
+func (e *nftExprs) data(typ uint16, value []byte) {
+	e.m.nest(typ|unix.NLA_F_NESTED, func() {
+		e.m.attr(unix.NFTA_DATA_VALUE, value)
+	})
+}
+
+func (e *nftExprs) meta(key uint32) {
+	e.expr("meta", func() {
+		e.m.attrBE32(unix.NFTA_META_DREG, unix.NFT_REG_1)
+		e.m.attrBE32(unix.NFTA_META_KEY, key)
+	})
+}
+
+func (e *nftExprs) cmp(op uint32, value []byte) {
+	e.expr("cmp", func() {
+		e.m.attrBE32(unix.NFTA_CMP_SREG, unix.NFT_REG_1)
+		e.m.attrBE32(unix.NFTA_CMP_OP, op)
+		e.data(unix.NFTA_CMP_DATA, value)
+	})
+}
+
+func (e *nftExprs) payload(base, offset, length uint32) {
+	e.expr("payload", func() {
+		e.m.attrBE32(unix.NFTA_PAYLOAD_DREG, unix.NFT_REG_1)
+		e.m.attrBE32(unix.NFTA_PAYLOAD_BASE, base)
+		e.m.attrBE32(unix.NFTA_PAYLOAD_OFFSET, offset)
+		e.m.attrBE32(unix.NFTA_PAYLOAD_LEN, length)
+	})
+}
+
+// nfproto matches packets of the address family of `addr`.
+// This is required before matching on the network header in an inet table.
+func (e *nftExprs) nfproto(addr netip.Addr) {
+	e.meta(unix.NFT_META_NFPROTO)
+	e.cmp(unix.NFT_CMP_EQ, []byte{nfproto(addr)})
+}
+
+// prefix compares the address at `offset` in the network header against the subnet `p`.
+func (e *nftExprs) prefix(offset uint32, p netip.Prefix, op uint32) {
+	addr := p.Addr().AsSlice()
+	e.payload(unix.NFT_PAYLOAD_NETWORK_HEADER, offset, uint32(len(addr)))
+	if p.Bits() < len(addr)*8 {
+		mask := make([]byte, len(addr))
+		for i := 0; i < p.Bits(); i++ {
+			mask[i/8] |= 0x80 >> (i % 8)
+		}
+		e.expr("bitwise", func() {
+			e.m.attrBE32(unix.NFTA_BITWISE_SREG, unix.NFT_REG_1)
+			e.m.attrBE32(unix.NFTA_BITWISE_DREG, unix.NFT_REG_1)
+			e.m.attrBE32(unix.NFTA_BITWISE_LEN, uint32(len(addr)))
+			e.data(unix.NFTA_BITWISE_MASK, mask)
+			e.data(unix.NFTA_BITWISE_XOR, make([]byte, len(addr)))
+		})
+	}
+	e.cmp(op, addr)
+}
+
+// fibDaddrType loads the route type (e.g. RTN_LOCAL) of the packet's destination address.
+func (e *nftExprs) fibDaddrType() {
+	e.expr("fib", func() {
+		e.m.attrBE32(unix.NFTA_FIB_DREG, unix.NFT_REG_1)
+		e.m.attrBE32(unix.NFTA_FIB_RESULT, unix.NFT_FIB_RESULT_ADDRTYPE)
+		e.m.attrBE32(unix.NFTA_FIB_FLAGS, unix.NFTA_FIB_F_DADDR)
+	})
+}
+
+func (e *nftExprs) jump(chain string) {
+	e.expr("immediate", func() {
+		e.m.attrBE32(unix.NFTA_IMMEDIATE_DREG, unix.NFT_REG_VERDICT)
+		e.m.nest(unix.NFTA_IMMEDIATE_DATA|unix.NLA_F_NESTED, func() {
+			e.m.nest(unix.NFTA_DATA_VERDICT|unix.NLA_F_NESTED, func() {
+				code := int32(unix.NFT_JUMP)
+				e.m.attrBE32(unix.NFTA_VERDICT_CODE, uint32(code))
+				e.m.attrString(unix.NFTA_VERDICT_CHAIN, chain)
+			})
+		})
+	})
+}
+
+// dnat rewrites the destination of the packet to `addr`:`port`.
+// This uses NFT_REG_1 for the address and NFT_REG_2 for the port.
+func (e *nftExprs) dnat(addr netip.Addr, port uint16) {
+	for _, v := range []struct {
+		reg   uint32
+		value []byte
+	}{
+		{unix.NFT_REG_1, addr.AsSlice()},
+		{unix.NFT_REG_2, be16(port)},
+	} {
+		e.expr("immediate", func() {
+			e.m.attrBE32(unix.NFTA_IMMEDIATE_DREG, v.reg)
+			e.data(unix.NFTA_IMMEDIATE_DATA, v.value)
+		})
+	}
+	e.expr("nat", func() {
+		e.m.attrBE32(unix.NFTA_NAT_TYPE, unix.NFT_NAT_DNAT)
+		e.m.attrBE32(unix.NFTA_NAT_FAMILY, uint32(nfproto(addr)))
+		e.m.attrBE32(unix.NFTA_NAT_REG_ADDR_MIN, unix.NFT_REG_1)
+		e.m.attrBE32(unix.NFTA_NAT_REG_PROTO_MIN, unix.NFT_REG_2)
+	})
+}
+
+func nfproto(addr netip.Addr) byte {
+	if addr.Is4() {
+		return unix.NFPROTO_IPV4
+	}
+	return unix.NFPROTO_IPV6
+}
+
+// ipSrcOffset returns the offset of the source address in the IP header.
+func ipSrcOffset(addr netip.Addr) uint32 {
+	if addr.Is4() {
+		return 12
+	}
+	return 8
+}
+
+// ipDstOffset returns the offset of the destination address in the IP header.
+func ipDstOffset(addr netip.Addr) uint32 {
+	if addr.Is4() {
+		return 16
+	}
+	return 24
+}
+
+func be16(v uint16) []byte {
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], v)
+	return b[:]
+}
+
+func nativeU32(v uint32) []byte {
+	var b [4]byte
+	nativeEndian.PutUint32(b[:], v)
+	return b[:]
+}
@@ -0,0 +1,193 @@
+package gonso
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestNAT(t *testing.T) {
+	host, err := Unshare(NS_NET, WithLoopback())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer host.Close()
+
+	b, err := NewBridge(host, BridgeOpts{
+		Name:      "gonso0",
+		Subnet:    netip.MustParsePrefix("10.88.0.0/24"),
+		StatePath: filepath.Join(t.TempDir(), "bridge.json"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	sandbox, err := Unshare(NS_NET, WithLoopback(), b.WithAttach("eth0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sandbox.Close()
+	addrs, err := sandbox.AddrList("eth0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sandboxAddr := addrs[0].Prefix.Addr()
+
+	// "outside" is only reachable from the sandbox through the host.
+	outside, err := Unshare(NS_NET)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer outside.Close()
+	if err := Veth(host, outside, "uplink", "eth0", VethOpts{Up: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := host.AddrAdd("uplink", netip.MustParsePrefix("192.0.2.1/24")); err != nil {
+		t.Fatal(err)
+	}
+	if err := outside.AddrAdd("eth0", netip.MustParsePrefix("192.0.2.2/24")); err != nil {
+		t.Fatal(err)
+	}
+	doNet(t, host, func() error {
+		return os.WriteFile("/proc/sys/net/ipv4/ip_forward", []byte("1"), 0)
+	})
+
+	cfg := NATConfig{
+		Masquerade: []netip.Prefix{netip.MustParsePrefix("10.88.0.0/24"), netip.MustParsePrefix("fd00::/64")},
+		PortMaps: []PortMap{
+			{Protocol: "tcp", HostPort: 8080, Addr: sandboxAddr, Port: 80},
+			{Protocol: "udp", HostPort: 5353, Addr: netip.MustParseAddr("fd00::2")},
+		},
+	}
+	if err := host.ApplyNAT(cfg); err != nil {
+		t.Fatal(err)
+	}
+	// Applying again replaces the rules.
+	if err := host.ApplyNAT(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	// Port map: connect to the host's address on the uplink from outside.
+	sandboxL := listen(t, sandbox, "tcp", ":80")
+	defer sandboxL.Close()
+	go serveRemoteAddr(sandboxL)
+
+	remote, err := dialRead(outside, "192.0.2.1:8080")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if remote.Addr() != netip.MustParseAddr("192.0.2.2") {
+		t.Errorf("expected port mapped connection from 192.0.2.2, got %s", remote)
+	}
+
+	// Connections made on the host are mapped as well.
+	if _, err := dialRead(host, netip.AddrPortFrom(b.Gateway(), 8080).String()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Masquerade: the sandbox's address is hidden from outside.
+	outsideL := listen(t, outside, "tcp", "192.0.2.2:9000")
+	defer outsideL.Close()
+	go serveRemoteAddr(outsideL)
+
+	remote, err = dialRead(sandbox, "192.0.2.2:9000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if remote.Addr() != netip.MustParseAddr("192.0.2.1") {
+		t.Errorf("expected masqueraded connection from 192.0.2.1, got %s", remote)
+	}
+
+	// Invalid config leaves the current rules in place.
+	bad := cfg
+	bad.PortMaps = append(bad.PortMaps, PortMap{Protocol: "sctp", HostPort: 1, Addr: sandboxAddr})
+	if err := host.ApplyNAT(bad); !errors.Is(err, unix.EINVAL) {
+		t.Errorf("expected EINVAL, got: %v", err)
+	}
+	if _, err := dialRead(outside, "192.0.2.1:8080"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := host.RemoveNAT(""); err != nil {
+		t.Fatal(err)
+	}
+	if err := host.RemoveNAT(""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dialRead(outside, "192.0.2.1:8080"); !errors.Is(err, unix.ECONNREFUSED) {
+		t.Errorf("expected connection refused once the rules are removed, got: %v", err)
+	}
+}
+
+// doNet runs `f` in the network namespace of `s`.
+func doNet(t *testing.T, s Set, f func() error) {
+	t.Helper()
+	netns, err := s.Dup(NS_NET)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer netns.Close()
+
+	var fErr error
+	if err := netns.Do(func() { fErr = f() }); err != nil {
+		t.Fatal(err)
+	}
+	if fErr != nil {
+		t.Fatal(fErr)
+	}
+}
+
+func listen(t *testing.T, s Set, network, addr string) net.Listener {
+	t.Helper()
+	var l net.Listener
+	doNet(t, s, func() (err error) {
+		l, err = net.Listen(network, addr)
+		return err
+	})
+	return l
+}
+
+// serveRemoteAddr writes the remote address of each connection back to it.
+func serveRemoteAddr(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		conn.Write([]byte(conn.RemoteAddr().String()))
+		conn.Close()
+	}
+}
+
+// dialRead connects to `addr` from the network namespace of `s` and returns
+// the remote address reported by `serveRemoteAddr`.
+func dialRead(s Set, addr string) (netip.AddrPort, error) {
+	netns, err := s.Dup(NS_NET)
+	if err != nil {
+		return netip.AddrPort{}, err
+	}
+	defer netns.Close()
+
+	var conn net.Conn
+	if doErr := netns.Do(func() { conn, err = net.DialTimeout("tcp", addr, 5*time.Second) }); doErr != nil {
+		return netip.AddrPort{}, doErr
+	}
+	if err != nil {
+		return netip.AddrPort{}, err
+	}
+	defer conn.Close()
+
+	data, err := io.ReadAll(conn)
+	if err != nil {
+		return netip.AddrPort{}, err
+	}
+	return netip.ParseAddrPort(string(data))
+}
@@ -0,0 +1,338 @@
+package gonso
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// nativeEndian is the byte order used by netlink, which is the byte order of the host.
+var nativeEndian binary.ByteOrder = func() binary.ByteOrder {
+	x := uint16(1)
+	if *(*byte)(unsafe.Pointer(&x)) == 1 {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}()
+
+const netlinkBufSize = 1 << 16
+
+// netlinkConn is a netlink socket, e.g. NETLINK_ROUTE.
+//
+// A netlink socket stays bound to the network namespace it was created in,
+// so a socket created inside a set can be used from any thread afterwards.
+type netlinkConn struct {
+	mu  sync.Mutex
+	fd  int
+	seq uint32
+}
+
+func newNetlinkConn(proto int) (*netlinkConn, error) {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, proto)
+	if err != nil {
+		return nil, fmt.Errorf("error creating netlink socket: %w", err)
+	}
+	if err := unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		sys_close(fd)
+		return nil, fmt.Errorf("error binding netlink socket: %w", err)
+	}
+	return &netlinkConn{fd: fd}, nil
+}
+
+// netlink creates a NETLINK_ROUTE socket in the set's network namespace.
+// The set must contain a network namespace.
+func (s Set) netlink() (*netlinkConn, error) {
+	return s.netlinkProto(unix.NETLINK_ROUTE)
+}
+
+// netlinkProto creates a netlink socket for the netlink protocol `proto` in the set's network namespace.
+// The set must contain a network namespace.
+func (s Set) netlinkProto(proto int) (*netlinkConn, error) {
+	if _, ok := s.fds[unix.CLONE_NEWNET]; !ok {
+		return nil, fmt.Errorf("flag not in set for %s", nsFlagsReverse[unix.CLONE_NEWNET])
+	}
+
+	// Only the network namespace is needed, this also drops any user namespace which can't be joined with Do.
+	netns, err := s.Dup(unix.CLONE_NEWNET)
+	if err != nil {
+		return nil, err
+	}
+	defer netns.Close()
+
+	var c *netlinkConn
+	if doErr := netns.Do(func() { c, err = newNetlinkConn(proto) }); doErr != nil {
+		return nil, doErr
+	}
+	return c, err
+}
+
+// Netlink is a NETLINK_ROUTE socket in the network namespace of a set.
+//
+// The helpers on `Set`, such as `Set.LinkUp` or `Set.AddrAdd`, open a new
+// socket for every call. A Netlink keeps one socket around so several
+// changes can be made without joining the namespace each time.
+// It can be used from any goroutine and does not need to be in the set's
+// namespaces. Create one with `Set.Netlink` and close it when done.
+type Netlink struct {
+	c *netlinkConn
+}
+
+// Netlink opens a netlink handle for the set's network namespace.
+// The set must contain a network namespace.
+func (s Set) Netlink() (*Netlink, error) {
+	c, err := s.netlink()
+	if err != nil {
+		return nil, err
+	}
+	return &Netlink{c: c}, nil
+}
+
+// Close closes the underlying netlink socket.
+func (n *Netlink) Close() error {
+	return n.c.Close()
+}
+
+func (c *netlinkConn) Close() error {
+	sys_close(c.fd)
+	return nil
+}
+
+// execute sends a request and waits for it to be acknowledged.
+// Any messages received in reply to the request (e.g. the results of a dump) are returned.
+func (c *netlinkConn) execute(typ, flags uint16, payload []byte) ([]syscall.NetlinkMessage, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.seq++
+	seq := c.seq
+	if err := c.send(appendNetlinkMessage(nil, typ, flags|unix.NLM_F_ACK, seq, payload)); err != nil {
+		return nil, err
+	}
+
+	var replies []syscall.NetlinkMessage
+	err := c.receive(func(m syscall.NetlinkMessage) (bool, error) {
+		if m.Header.Seq != seq {
+			// Stale reply to an earlier request.
+			return false, nil
+		}
+		switch m.Header.Type {
+		case unix.NLMSG_ERROR, unix.NLMSG_DONE:
+			return true, netlinkError(m)
+		default:
+			// The buffer is reused for the next read.
+			m.Data = append([]byte(nil), m.Data...)
+			replies = append(replies, m)
+			return false, nil
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return replies, nil
+}
+
+// netlinkRequest is a single message in a batch sent with `executeBatch`.
+type netlinkRequest struct {
+	typ     uint16
+	flags   uint16
+	payload []byte
+}
+
+// executeBatch sends all requests with a single write and waits for every
+// request with NLM_F_ACK set to be acknowledged.
+// The first error reported for any of the requests is returned.
+func (c *netlinkConn) executeBatch(reqs []netlinkRequest) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var msg []byte
+	pending := make(map[uint32]bool)
+	for _, r := range reqs {
+		c.seq++
+		msg = appendNetlinkMessage(msg, r.typ, r.flags, c.seq, r.payload)
+		if r.flags&unix.NLM_F_ACK != 0 {
+			pending[c.seq] = true
+		}
+	}
+	if err := c.send(msg); err != nil {
+		return err
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	return c.receive(func(m syscall.NetlinkMessage) (bool, error) {
+		if m.Header.Type != unix.NLMSG_ERROR || !pending[m.Header.Seq] {
+			return false, nil
+		}
+		if err := netlinkError(m); err != nil {
+			return true, err
+		}
+		delete(pending, m.Header.Seq)
+		return len(pending) == 0, nil
+	})
+}
+
+func appendNetlinkMessage(b []byte, typ, flags uint16, seq uint32, payload []byte) []byte {
+	var hdr [unix.NLMSG_HDRLEN]byte
+	nativeEndian.PutUint32(hdr[0:4], uint32(unix.NLMSG_HDRLEN+len(payload)))
+	nativeEndian.PutUint16(hdr[4:6], typ)
+	nativeEndian.PutUint16(hdr[6:8], flags|unix.NLM_F_REQUEST)
+	nativeEndian.PutUint32(hdr[8:12], seq)
+	b = append(b, hdr[:]...)
+	b = append(b, payload...)
+	for len(b)%unix.NLMSG_ALIGNTO != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+func (c *netlinkConn) send(msg []byte) error {
+	for {
+		err := unix.Sendto(c.fd, msg, 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK})
+		if err == nil {
+			return nil
+		}
+		if err != unix.EINTR {
+			return fmt.Errorf("error sending netlink request: %w", err)
+		}
+	}
+}
+
+// receive reads messages and passes them to `f` until it returns true or an error.
+func (c *netlinkConn) receive(f func(syscall.NetlinkMessage) (bool, error)) error {
+	buf := make([]byte, netlinkBufSize)
+	for {
+		n, _, err := unix.Recvfrom(c.fd, buf, 0)
+		if err == unix.EINTR {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("error receiving netlink reply: %w", err)
+		}
+
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			return fmt.Errorf("error parsing netlink reply: %w", err)
+		}
+		for _, m := range msgs {
+			done, err := f(m)
+			if err != nil || done {
+				return err
+			}
+		}
+	}
+}
+
+// netlinkError returns the error carried by an NLMSG_ERROR or NLMSG_DONE message, if any.
+func netlinkError(m syscall.NetlinkMessage) error {
+	if len(m.Data) >= 4 {
+		if code := int32(nativeEndian.Uint32(m.Data[:4])); code < 0 {
+			return unix.Errno(-code)
+		}
+	}
+	return nil
+}
+
+// netlinkMsg builds the payload of a netlink request.
+type netlinkMsg struct {
+	b []byte
+}
+
+// netlinkHeader appends a fixed size header, e.g. a unix.IfInfomsg.
+func netlinkHeader[T any](m *netlinkMsg, v *T) {
+	m.b = append(m.b, unsafe.Slice((*byte)(unsafe.Pointer(v)), unsafe.Sizeof(*v))...)
+	m.align()
+}
+
+func (m *netlinkMsg) align() {
+	for len(m.b)%unix.NLMSG_ALIGNTO != 0 {
+		m.b = append(m.b, 0)
+	}
+}
+
+func (m *netlinkMsg) attr(typ uint16, data []byte) {
+	var hdr [unix.SizeofRtAttr]byte
+	nativeEndian.PutUint16(hdr[0:2], uint16(unix.SizeofRtAttr+len(data)))
+	nativeEndian.PutUint16(hdr[2:4], typ)
+	m.b = append(m.b, hdr[:]...)
+	m.b = append(m.b, data...)
+	m.align()
+}
+
+func (m *netlinkMsg) attrU32(typ uint16, v uint32) {
+	var b [4]byte
+	nativeEndian.PutUint32(b[:], v)
+	m.attr(typ, b[:])
+}
+
+// attrBE32 adds a u32 attribute in network byte order, as used by e.g. nf_tables.
+func (m *netlinkMsg) attrBE32(typ uint16, v uint32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], v)
+	m.attr(typ, b[:])
+}
+
+// attrString adds a null terminated string attribute.
+func (m *netlinkMsg) attrString(typ uint16, s string) {
+	m.attr(typ, append([]byte(s), 0))
+}
+
+// nest adds an attribute containing the attributes added by f.
+func (m *netlinkMsg) nest(typ uint16, f func()) {
+	start := len(m.b)
+	m.attr(typ, nil)
+	f()
+	nativeEndian.PutUint16(m.b[start:start+2], uint16(len(m.b)-start))
+}
+
+// parseHeader decodes the fixed size header at the start of a netlink message payload.
+func parseHeader[T any](data []byte) (*T, []byte, error) {
+	var v T
+	size := int(unsafe.Sizeof(v))
+	if len(data) < size {
+		return nil, nil, fmt.Errorf("netlink message too short: %w", unix.EINVAL)
+	}
+	copy(unsafe.Slice((*byte)(unsafe.Pointer(&v)), size), data)
+
+	aligned := (size + unix.NLMSG_ALIGNTO - 1) &^ (unix.NLMSG_ALIGNTO - 1)
+	if aligned > len(data) {
+		aligned = len(data)
+	}
+	return &v, data[aligned:], nil
+}
+
+// parseAttrs decodes a list of netlink attributes.
+// If an attribute type appears multiple times the last one wins.
+func parseAttrs(b []byte) map[uint16][]byte {
+	attrs := make(map[uint16][]byte)
+	for len(b) >= unix.SizeofRtAttr {
+		l := int(nativeEndian.Uint16(b[0:2]))
+		typ := nativeEndian.Uint16(b[2:4]) &^ (unix.NLA_F_NESTED | unix.NLA_F_NET_BYTEORDER)
+		if l < unix.SizeofRtAttr || l > len(b) {
+			break
+		}
+		attrs[typ] = b[unix.SizeofRtAttr:l]
+
+		l = (l + unix.NLMSG_ALIGNTO - 1) &^ (unix.NLMSG_ALIGNTO - 1)
+		if l > len(b) {
+			break
+		}
+		b = b[l:]
+	}
+	return attrs
+}
+
+// attrString decodes a null terminated string attribute.
+func attrString(b []byte) string {
+	for i, c := range b {
+		if c == 0 {
+			return string(b[:i])
+		}
+	}
+	return string(b)
+}
@@ -0,0 +1,100 @@
+package gonso
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+)
+
+func TestNetlink(t *testing.T) {
+	maps := []IDMap{{ContainerID: 0, HostID: 0, Size: 1}}
+	set, err := Unshare(NS_NET|NS_USER, WithIDMaps(maps, maps))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer set.Close()
+
+	if err := Veth(set, set, "veth0", "veth1", VethOpts{}); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := set.Netlink()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer n.Close()
+
+	if err := n.LinkUp("veth0"); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.LinkUp("veth1"); err != nil {
+		t.Fatal(err)
+	}
+
+	prefix := netip.MustParsePrefix("10.99.0.2/24")
+	if err := n.AddrAdd("veth0", prefix); err != nil {
+		t.Fatal(err)
+	}
+	gw := netip.MustParseAddr("10.99.0.1")
+	if err := n.RouteAdd(Route{Gateway: gw, Link: "veth0"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Changes made through the handle are the same as through the set.
+	addrs, err := set.AddrList("veth0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found bool
+	for _, a := range addrs {
+		if a.Prefix == prefix {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("expected %s on veth0, got: %v", prefix, addrs)
+	}
+
+	routes, err := n.RouteList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	found = false
+	for _, r := range routes {
+		if r.Dst.Bits() == 0 && r.Gateway == gw && r.Link == "veth0" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("expected default route via %s, got: %+v", gw, routes)
+	}
+
+	if err := n.RouteDel(Route{Gateway: gw}); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.AddrDel("veth0", prefix); err != nil {
+		t.Fatal(err)
+	}
+	addrs, err = n.AddrList("veth0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, a := range addrs {
+		if a.Prefix == prefix {
+			t.Errorf("expected %s to be removed", prefix)
+		}
+	}
+
+	if err := n.LinkDown("veth0"); err != nil {
+		t.Fatal(err)
+	}
+	links, err := n.LinkList()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, l := range links {
+		if l.Name == "veth0" && l.Flags&net.FlagUp != 0 {
+			t.Error("expected veth0 to be down")
+		}
+	}
+}
@@ -0,0 +1,332 @@
+package gonso
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// OverlayOpts configures an overlay created by `Set.Overlay` or `NewOverlay`.
+type OverlayOpts struct {
+	// Lower are the read-only directories making up the base of the overlay.
+	// The first directory is the top-most layer.
+	// The directories are resolved in the caller's mount namespace.
+	Lower []string
+	// ScratchDir is where the upper and work directories of the overlay are
+	// created. If empty, `os.TempDir` is used.
+	// It must be on a filesystem which supports being used as an overlay upper directory.
+	ScratchDir string
+}
+
+// Overlay is a copy-on-write view of a set of directories mounted inside a
+// mount namespace.
+// All writes are captured in a scratch directory and can be inspected with
+// `Diff`.
+type Overlay struct {
+	set     Set
+	ownsSet bool
+	target  string
+	scratch string
+	lower   []string
+
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// Overlay mounts an overlay filesystem at `target` in the set's mount namespace.
+// The set must contain a mount namespace.
+//
+// If the mount `target` is on is shared it is made a slave first, so the
+// overlay does not propagate to its peers, e.g. the caller's mount namespace.
+// Mounts propagated into it from its old peers are still received.
+//
+// The set is not closed when the overlay is closed.
+func (s Set) Overlay(target string, opts OverlayOpts) (*Overlay, error) {
+	return newOverlay(s, false, target, opts)
+}
+
+// NewOverlay creates a new mount namespace with private mount propagation
+// and mounts an overlay filesystem at `target` inside it.
+// The new namespace can be accessed with `Overlay.Set`.
+//
+// `unshareOpts` are passed to `Unshare` when creating the mount namespace.
+// The mount namespace is closed when the overlay is closed.
+func NewOverlay(target string, opts OverlayOpts, unshareOpts ...UnshareOpt) (*Overlay, error) {
+	unshareOpts = append([]UnshareOpt{WithMountPropagation(unix.MS_PRIVATE, true)}, unshareOpts...)
+	s, err := Unshare(unix.CLONE_NEWNS, unshareOpts...)
+	if err != nil {
+		return nil, err
+	}
+	o, err := newOverlay(s, true, target, opts)
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	return o, nil
+}
+
+func newOverlay(s Set, ownsSet bool, target string, opts OverlayOpts) (_ *Overlay, retErr error) {
+	if len(opts.Lower) == 0 {
+		return nil, fmt.Errorf("at least one lower directory is required: %w", unix.EINVAL)
+	}
+	if _, ok := s.fds[unix.CLONE_NEWNS]; !ok {
+		return nil, fmt.Errorf("flag not in set for %s", nsFlagsReverse[unix.CLONE_NEWNS])
+	}
+
+	scratch, err := os.MkdirTemp(opts.ScratchDir, "gonso-overlay-")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if retErr != nil {
+			os.RemoveAll(scratch)
+		}
+	}()
+
+	o := &Overlay{set: s, ownsSet: ownsSet, target: target, scratch: scratch}
+	for _, p := range []string{o.UpperDir(), o.workDir()} {
+		if err := os.Mkdir(p, 0o755); err != nil {
+			return nil, err
+		}
+	}
+
+	lower := make([]string, 0, len(opts.Lower))
+	for _, p := range opts.Lower {
+		p, err := filepath.Abs(p)
+		if err != nil {
+			return nil, err
+		}
+		o.lower = append(o.lower, p)
+		lower = append(lower, overlayEscaper.Replace(p))
+	}
+
+	m, err := NewFSMount("overlay", FSMountOpts{
+		Source: "overlay",
+		Options: []string{
+			"lowerdir=" + strings.Join(lower, ":"),
+			"upperdir=" + o.UpperDir(),
+			"workdir=" + o.workDir(),
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer m.Close()
+
+	if !ownsSet {
+		// The namespace from `NewOverlay` is already private.
+		if err := s.slaveMount(target); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.MoveMount(m, target); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// slaveMount makes the mount which `p` is on in the set's mount namespace a
+// slave mount if it is shared.
+func (s Set) slaveMount(p string) error {
+	var retErr error
+	err := s.doMount(func() {
+		retErr = func() error {
+			var st unix.Statx_t
+			if err := statx(unix.AT_FDCWD, p, 0, unix.STATX_MNT_ID, &st); err != nil {
+				return &os.PathError{Op: "statx", Path: p, Err: err}
+			}
+
+			f, err := os.Open("/proc/thread-self/mountinfo")
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+
+			mounts, err := ReadMountInfo(f, func(m MountInfo) bool { return uint64(m.ID) == st.Mnt_id })
+			if err != nil {
+				return err
+			}
+			if len(mounts) == 0 {
+				return fmt.Errorf("mount of %s not found: %w", p, unix.ENOENT)
+			}
+			if _, ok := mounts[0].Shared(); !ok {
+				return nil
+			}
+			if err := mountPropagation(mounts[0].Mountpoint, unix.MS_SLAVE); err != nil {
+				return fmt.Errorf("error making %s a slave mount: %w", mounts[0].Mountpoint, err)
+			}
+			return nil
+		}()
+	})
+	if err != nil {
+		return err
+	}
+	return retErr
+}
+
+// overlayEscaper escapes characters in paths which are special in the lowerdir option.
+var overlayEscaper = strings.NewReplacer(`\`, `\\`, `:`, `\:`)
+
+// Set returns a copy of the set the overlay is mounted in.
+// The caller is responsible for closing the returned set.
+func (o *Overlay) Set() (Set, error) {
+	return o.set.Dup(0)
+}
+
+// Target is where the overlay is mounted in the set's mount namespace.
+func (o *Overlay) Target() string {
+	return o.target
+}
+
+// UpperDir is the directory which holds all changes made to the overlay.
+// It is resolved in the caller's mount namespace.
+func (o *Overlay) UpperDir() string {
+	return filepath.Join(o.scratch, "upper")
+}
+
+func (o *Overlay) workDir() string {
+	return filepath.Join(o.scratch, "work")
+}
+
+// Close unmounts the overlay and removes the scratch directory.
+// If the overlay was created by `NewOverlay` the mount namespace is closed as well.
+// It is safe to call Close multiple times.
+func (o *Overlay) Close() error {
+	o.closeOnce.Do(func() {
+		err := o.set.doMount(func() { unmount(o.target) })
+		if rmErr := os.RemoveAll(o.scratch); rmErr != nil && err == nil {
+			err = rmErr
+		}
+		if o.ownsSet {
+			if closeErr := o.set.Close(); closeErr != nil && err == nil {
+				err = closeErr
+			}
+		}
+		o.closeErr = err
+	})
+	return o.closeErr
+}
+
+// ChangeKind is the kind of change made to a path in an overlay.
+type ChangeKind int
+
+const (
+	// ChangeAdd is a path which does not exist in any of the lower directories.
+	ChangeAdd ChangeKind = iota
+	// ChangeModify is a path which exists in a lower directory and was
+	// modified, including changes to its metadata.
+	ChangeModify
+	// ChangeDelete is a path which was removed.
+	ChangeDelete
+)
+
+func (k ChangeKind) String() string {
+	switch k {
+	case ChangeAdd:
+		return "add"
+	case ChangeModify:
+		return "modify"
+	case ChangeDelete:
+		return "delete"
+	default:
+		return fmt.Sprintf("ChangeKind(%d)", int(k))
+	}
+}
+
+// Change is a single change made to an overlay.
+type Change struct {
+	// Path is the path relative to the root of the overlay, always starting with "/".
+	Path string
+	Kind ChangeKind
+	// Opaque is set for directories which replace the directory of the same
+	// name in the lower directories rather than being merged with it, e.g.
+	// when a directory is removed and created again.
+	Opaque bool
+}
+
+// Diff returns the changes made to the overlay compared to the lower directories,
+// in lexical order of their paths.
+//
+// Whiteouts (character devices with device number 0/0) are reported as
+// deletions and opaque directories have `Change.Opaque` set.
+func (o *Overlay) Diff() ([]Change, error) {
+	var (
+		changes []Change
+		// opaque holds the opaque directory currently being walked, if any.
+		// Nothing below it is merged with the lower directories.
+		opaque string
+	)
+	upper := o.UpperDir()
+	err := filepath.WalkDir(upper, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == upper {
+			return nil
+		}
+
+		rel := "/" + filepath.ToSlash(strings.TrimPrefix(p, upper+string(filepath.Separator)))
+		c := Change{Path: rel, Kind: ChangeAdd}
+
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if isWhiteout(fi) {
+			c.Kind = ChangeDelete
+			changes = append(changes, c)
+			return nil
+		}
+		if opaque != "" && !strings.HasPrefix(rel, opaque+"/") {
+			opaque = ""
+		}
+		if opaque != "" {
+			changes = append(changes, c)
+			return nil
+		}
+		if d.IsDir() {
+			c.Opaque = isOpaque(p)
+		}
+
+		for _, l := range o.lower {
+			if _, err := os.Lstat(filepath.Join(l, rel)); err == nil {
+				c.Kind = ChangeModify
+				break
+			}
+		}
+		if c.Opaque {
+			opaque = rel
+		}
+		changes = append(changes, c)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error walking overlay upper dir: %w", err)
+	}
+	return changes, nil
+}
+
+func isWhiteout(fi fs.FileInfo) bool {
+	if fi.Mode()&fs.ModeCharDevice == 0 {
+		return false
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	return ok && st.Rdev == 0
+}
+
+func isOpaque(p string) bool {
+	for _, name := range []string{"trusted.overlay.opaque", "user.overlay.opaque"} {
+		buf := make([]byte, 1)
+		n, err := unix.Lgetxattr(p, name, buf)
+		if err == nil && n == 1 && buf[0] == 'y' {
+			return true
+		}
+	}
+	return false
+}
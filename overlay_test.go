@@ -0,0 +1,146 @@
+package gonso
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestOverlay(t *testing.T) {
+	lower := t.TempDir()
+	for p, content := range map[string]string{"a": "a", "b": "b", "d/x": "x"} {
+		p = filepath.Join(lower, p)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	target := t.TempDir()
+	o, err := NewOverlay(target, OverlayOpts{Lower: []string{lower}, ScratchDir: t.TempDir()})
+	if errors.Is(err, unix.ENODEV) {
+		t.Skip("overlay not supported")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer o.Close()
+
+	set, err := o.Set()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer set.Close()
+
+	err = set.Do(func() {
+		data, err := os.ReadFile(filepath.Join(target, "a"))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if string(data) != "a" {
+			t.Errorf("expected lower content, got %q", string(data))
+		}
+
+		for _, err := range []error{
+			os.WriteFile(filepath.Join(target, "a"), []byte("changed"), 0o644),
+			os.WriteFile(filepath.Join(target, "c"), []byte("c"), 0o644),
+			os.Remove(filepath.Join(target, "b")),
+			os.RemoveAll(filepath.Join(target, "d")),
+			os.Mkdir(filepath.Join(target, "d"), 0o755),
+			os.WriteFile(filepath.Join(target, "d", "x"), []byte("new x"), 0o644),
+		} {
+			if err != nil {
+				t.Error(err)
+			}
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The overlay is not visible outside of its mount namespace and the lower dir is unchanged.
+	if _, err := os.Stat(filepath.Join(target, "a")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected overlay to not be visible outside the set: %v", err)
+	}
+	if data, err := os.ReadFile(filepath.Join(lower, "a")); err != nil || string(data) != "a" {
+		t.Errorf("expected lower dir to be unchanged: %q, %v", string(data), err)
+	}
+
+	changes, err := o.Diff()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Change{
+		{Path: "/a", Kind: ChangeModify},
+		{Path: "/b", Kind: ChangeDelete},
+		{Path: "/c", Kind: ChangeAdd},
+		{Path: "/d", Kind: ChangeModify, Opaque: true},
+		{Path: "/d/x", Kind: ChangeAdd},
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("expected changes %+v, got %+v", expected, changes)
+	}
+
+	upper := o.UpperDir()
+	if err := o.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(upper); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected scratch dir to be removed: %v", err)
+	}
+}
+
+func TestSetOverlayShared(t *testing.T) {
+	lower := t.TempDir()
+	if err := os.WriteFile(filepath.Join(lower, "a"), []byte("a"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// A shared mount whose copy in the new namespace is a peer of this one.
+	shared := t.TempDir()
+	if err := mountFS("tmpfs", shared, "tmpfs", 0, "size=64k"); err != nil {
+		t.Fatal(err)
+	}
+	defer unmount(shared)
+	if err := mountPropagation(shared, unix.MS_SHARED); err != nil {
+		t.Fatal(err)
+	}
+	target := filepath.Join(shared, "target")
+	if err := os.Mkdir(target, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	set, err := Unshare(NS_MNT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer set.Close()
+
+	o, err := set.Overlay(target, OverlayOpts{Lower: []string{lower}, ScratchDir: t.TempDir()})
+	if errors.Is(err, unix.ENODEV) {
+		t.Skip("overlay not supported")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer o.Close()
+
+	err = set.Do(func() {
+		if _, err := os.Stat(filepath.Join(target, "a")); err != nil {
+			t.Errorf("expected overlay to be mounted in the set: %v", err)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(target, "a")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected overlay to not propagate out of the set: %v", err)
+	}
+}
@@ -0,0 +1,201 @@
+package gonso
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/unix"
+)
+
+// PivotRoot creates a new Set with a new mount namespace, copied from the
+// set's mount namespace, which uses `newRoot` as its root filesystem.
+// All other namespaces in the returned Set are the same as in `s`.
+//
+// `newRoot` is bind mounted onto itself, a fresh /proc and a minimal /dev
+// (tmpfs with null, zero, full, random, urandom and tty bind mounted from the
+// set's /dev, plus devpts and shm) are mounted inside it, and then the root
+// is switched with `pivot_root(2)` and the old root is detached.
+// The /proc and /dev directories are created in `newRoot` if they do not exist.
+//
+// `newRoot` is resolved in the set's mount namespace, a relative path is
+// treated as relative to the caller's working directory.
+// The set must contain a mount namespace. The original set is not modified.
+//
+// The new /proc shows the pid namespace of the caller rather than one in the
+// set since a pid namespace can only be entered by new processes.
+func (s Set) PivotRoot(newRoot string) (_ Set, retErr error) {
+	if _, ok := s.fds[unix.CLONE_NEWNS]; !ok {
+		return Set{}, fmt.Errorf("flag not in set for %s", nsFlagsReverse[unix.CLONE_NEWNS])
+	}
+
+	newRoot, err := filepath.Abs(newRoot)
+	if err != nil {
+		return Set{}, err
+	}
+
+	if s.flags&unix.CLONE_NEWUSER == 0 {
+		return s.pivotRoot(newRoot)
+	}
+
+	// The new mount namespace must be owned by the set's user namespace so it
+	// has to be created by a child which has joined it, see `Unshare`.
+	//
+	// pivot_root(2) requires that neither the new root nor the old root are shared mounts.
+	// Making everything private also makes sure none of the mounts below leak out of the new namespace.
+	newS, err := s.Unshare(unix.CLONE_NEWNS, WithMountPropagation(unix.MS_PRIVATE, true))
+	if err != nil {
+		return Set{}, err
+	}
+	defer func() {
+		if retErr != nil {
+			newS.Close()
+		}
+	}()
+
+	var pivotErr error
+	if err := newS.doMount(func() { pivotErr = setupRoot(newRoot) }); err != nil {
+		return Set{}, err
+	}
+	if pivotErr != nil {
+		return Set{}, pivotErr
+	}
+	return newS, nil
+}
+
+// pivotRoot is PivotRoot for sets without a user namespace.
+// The new mount namespace is unshared from a thread in the set's mount
+// namespace so it is a copy of that rather than of the caller's.
+func (s Set) pivotRoot(newRoot string) (Set, error) {
+	var (
+		mntS Set
+		err  error
+	)
+	doErr := s.doMount(func() {
+		mntS, err = func() (_ Set, retErr error) {
+			if err := unshare(unix.CLONE_NEWNS); err != nil {
+				return Set{}, fmt.Errorf("error unsharing mount namespace: %w", err)
+			}
+			// pivot_root(2) requires that neither the new root nor the old root are shared mounts.
+			if err := mountPropagation("/", unix.MS_PRIVATE|unix.MS_REC); err != nil {
+				return Set{}, fmt.Errorf("error setting mount propagation: %w", err)
+			}
+
+			mntS, err := curNamespaces(unix.CLONE_NEWNS)
+			if err != nil {
+				return Set{}, fmt.Errorf("error getting namespaces: %w", err)
+			}
+			if err := setupRoot(newRoot); err != nil {
+				mntS.Close()
+				return Set{}, err
+			}
+			return mntS, nil
+		}()
+	})
+	if doErr != nil {
+		return Set{}, doErr
+	}
+	if err != nil {
+		return Set{}, err
+	}
+
+	if err := merge(s, &mntS); err != nil {
+		mntS.Close()
+		return Set{}, err
+	}
+	return mntS, nil
+}
+
+// setupRoot makes `newRoot` the root of the current thread's mount namespace.
+// The thread must have CLONE_FS unshared.
+func setupRoot(newRoot string) error {
+	// pivot_root(2) requires the new root to be a mount point.
+	if err := mountFS(newRoot, newRoot, "", unix.MS_BIND|unix.MS_REC, ""); err != nil {
+		return &os.PathError{Op: "bind mount", Path: newRoot, Err: err}
+	}
+
+	// These are mounted before pivoting since mounting proc in a user
+	// namespace requires an existing proc mount to be visible.
+	proc := filepath.Join(newRoot, "proc")
+	if err := os.MkdirAll(proc, 0o555); err != nil {
+		return err
+	}
+	if err := mountFS("proc", proc, "proc", unix.MS_NOSUID|unix.MS_NODEV|unix.MS_NOEXEC, ""); err != nil {
+		return &os.PathError{Op: "mount proc", Path: proc, Err: err}
+	}
+
+	if err := setupDev(filepath.Join(newRoot, "dev")); err != nil {
+		return err
+	}
+
+	// Pivot the new root on top of the old one and then detach the old one.
+	// This avoids needing a directory in the new root to hold the old root.
+	if err := chdir(newRoot); err != nil {
+		return &os.PathError{Op: "chdir", Path: newRoot, Err: err}
+	}
+	if err := pivotRoot(".", "."); err != nil {
+		return &os.PathError{Op: "pivot_root", Path: newRoot, Err: err}
+	}
+	if err := umount(".", unix.MNT_DETACH); err != nil {
+		return fmt.Errorf("error detaching old root: %w", err)
+	}
+	return chdir("/")
+}
+
+// devices are bind mounted from the existing /dev since device nodes cannot
+// be created in a user namespace.
+var devices = []string{"null", "zero", "full", "random", "urandom", "tty"}
+
+var devSymlinks = map[string]string{
+	"fd":     "/proc/self/fd",
+	"stdin":  "/proc/self/fd/0",
+	"stdout": "/proc/self/fd/1",
+	"stderr": "/proc/self/fd/2",
+	"ptmx":   "pts/ptmx",
+}
+
+// setupDev mounts a minimal /dev at `dev`.
+func setupDev(dev string) error {
+	if err := os.MkdirAll(dev, 0o755); err != nil {
+		return err
+	}
+	if err := mountFS("tmpfs", dev, "tmpfs", unix.MS_NOSUID|unix.MS_STRICTATIME, "mode=755,size=65536k"); err != nil {
+		return &os.PathError{Op: "mount tmpfs", Path: dev, Err: err}
+	}
+
+	for _, name := range devices {
+		p := filepath.Join(dev, name)
+		f, err := os.OpenFile(p, os.O_CREATE|os.O_WRONLY, 0o666)
+		if err != nil {
+			return err
+		}
+		f.Close()
+
+		if err := mountFS(filepath.Join("/dev", name), p, "", unix.MS_BIND, ""); err != nil {
+			return &os.PathError{Op: "bind mount", Path: p, Err: err}
+		}
+	}
+
+	for name, target := range devSymlinks {
+		if err := os.Symlink(target, filepath.Join(dev, name)); err != nil {
+			return err
+		}
+	}
+
+	pts := filepath.Join(dev, "pts")
+	if err := os.Mkdir(pts, 0o755); err != nil {
+		return err
+	}
+	if err := mountFS("devpts", pts, "devpts", unix.MS_NOSUID|unix.MS_NOEXEC, "newinstance,ptmxmode=0666,mode=0620"); err != nil {
+		return &os.PathError{Op: "mount devpts", Path: pts, Err: err}
+	}
+
+	shm := filepath.Join(dev, "shm")
+	if err := os.Mkdir(shm, 0o1777); err != nil {
+		return err
+	}
+	if err := mountFS("shm", shm, "tmpfs", unix.MS_NOSUID|unix.MS_NODEV|unix.MS_NOEXEC, "mode=1777,size=65536k"); err != nil {
+		return &os.PathError{Op: "mount tmpfs", Path: shm, Err: err}
+	}
+	return nil
+}
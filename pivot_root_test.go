@@ -0,0 +1,146 @@
+package gonso
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPivotRoot(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "marker"), []byte("hello"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	inSet := filepath.Join(root, "inset")
+	if err := os.Mkdir(inSet, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, flags := range map[string]int{"mnt": NS_MNT, "userns": NS_MNT | NS_USER} {
+		flags := flags
+		t.Run(name, func(t *testing.T) {
+			maps := []IDMap{{ContainerID: 0, HostID: 0, Size: 65536}}
+			var opts []UnshareOpt
+			if flags&NS_USER != 0 {
+				opts = append(opts, WithIDMaps(maps, maps))
+			}
+
+			set, err := Unshare(flags, opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer set.Close()
+
+			// Mounts made in the set should be carried over to the new mount namespace.
+			var mountErr error
+			err = set.doMount(func() {
+				if mountErr = mountFS("tmpfs", inSet, "tmpfs", 0, "size=64k"); mountErr != nil {
+					return
+				}
+				mountErr = os.WriteFile(filepath.Join(inSet, "marker"), []byte("inset"), 0o644)
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if mountErr != nil {
+				t.Fatal(mountErr)
+			}
+
+			pivoted, err := set.PivotRoot(root)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer pivoted.Close()
+
+			if pivoted.flags != set.flags {
+				t.Errorf("expected flags %d, got %d", set.flags, pivoted.flags)
+			}
+			id := func(s Set, ns int) string {
+				t.Helper()
+				id, err := s.ID(ns)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return id
+			}
+			if flags&NS_USER != 0 && id(pivoted, NS_USER) != id(set, NS_USER) {
+				t.Error("expected user namespace to be preserved")
+			}
+			if id(pivoted, NS_MNT) == id(set, NS_MNT) {
+				t.Error("expected a new mount namespace")
+			}
+			if flags&NS_USER != 0 && pivoted.testGetOwnerID(t, NS_MNT) != id(set, NS_USER) {
+				t.Error("expected the new mount namespace to be owned by the set's user namespace")
+			}
+
+			mnt, err := pivoted.Dup(NS_MNT)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer mnt.Close()
+
+			err = mnt.Do(func() {
+				data, err := os.ReadFile("/marker")
+				if err != nil {
+					t.Error(err)
+				} else if string(data) != "hello" {
+					t.Errorf("unexpected content in marker file: %q", string(data))
+				}
+
+				data, err = os.ReadFile("/inset/marker")
+				if err != nil {
+					t.Errorf("expected mount from the set to be present: %v", err)
+				} else if string(data) != "inset" {
+					t.Errorf("unexpected content in inset marker file: %q", string(data))
+				}
+
+				// The old root should be gone.
+				if _, err := os.Stat(root); !errors.Is(err, os.ErrNotExist) {
+					t.Errorf("expected old root to not be visible: %v", err)
+				}
+
+				if _, err := os.Stat("/proc/self/status"); err != nil {
+					t.Error(err)
+				}
+				if err := os.WriteFile("/dev/null", []byte("hello"), 0); err != nil {
+					t.Error(err)
+				}
+				if _, err := os.Stat("/dev/pts/ptmx"); err != nil {
+					t.Error(err)
+				}
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// The original set should still have the old root.
+			orig, err := set.Dup(NS_MNT)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer orig.Close()
+
+			err = orig.Do(func() {
+				if _, err := os.Stat(filepath.Join(root, "marker")); err != nil {
+					t.Error(err)
+				}
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+
+	t.Run("no mount namespace", func(t *testing.T) {
+		set, err := Unshare(NS_NET)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer set.Close()
+
+		if _, err := set.PivotRoot(root); err == nil {
+			t.Fatal("expected error")
+		}
+	})
+}
@@ -11,16 +11,11 @@ import (
 // It is safe to use a Pool from multiple goroutines.
 // Create one using `NewPool` with the flags you want to use for sets managed by this pool.
 type Pool struct {
-	mu    sync.Mutex
-	cvar  *sync.Cond
-	sets  []Set
+	filler[Set]
 	flags int
 
 	afterCreate func() error
-
-	// notify is used for testing purposes
-	// it is called when a set is created by `Run`
-	notify func()
+	opts        []UnshareOpt
 }
 
 // NewPool creates a new pool with the given flags.
@@ -28,11 +23,16 @@ type Pool struct {
 //
 // `afterCreate` is called after a set is created for the pool.
 // This is useful to set up the set before it is needed.
-func NewPool(flags int, afterCreate func() error) *Pool {
+//
+// `opts` are passed to `Unshare` when creating a set, e.g. `IDAllocator.WithIDMaps`
+// or `Bridge.WithAttach` to give each set its own resources.
+func NewPool(flags int, afterCreate func() error, opts ...UnshareOpt) *Pool {
 	p := &Pool{
-		flags: flags,
+		flags:       flags,
+		afterCreate: afterCreate,
+		opts:        opts,
 	}
-	p.cvar = sync.NewCond(&p.mu)
+	p.filler.init(p.getN, func(s Set) { s.Close() })
 	return p
 }
 
@@ -56,7 +56,7 @@ func (p *Pool) runAfterCreate(s Set) error {
 }
 
 func (p *Pool) get() (Set, error) {
-	s, err := Unshare(p.flags)
+	s, err := Unshare(p.flags, p.opts...)
 	if err != nil {
 		return Set{}, err
 	}
@@ -66,21 +66,38 @@ func (p *Pool) get() (Set, error) {
 	return s, nil
 }
 
+// getN creates `n` sets in one batch.
+// See `UnshareN`.
+func (p *Pool) getN(ctx context.Context, n int) ([]Set, error) {
+	sets, err := UnshareNContext(ctx, p.flags, n, p.opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, s := range sets {
+		if err := p.runAfterCreate(s); err != nil {
+			// runAfterCreate closes the set on error
+			for j, s := range sets {
+				if j != i {
+					s.Close()
+				}
+			}
+			return nil, err
+		}
+	}
+	return sets, nil
+}
+
 // Get returns a set from the pool.
 // If there are no sets available, Get will create a new one.
 func (p *Pool) Get() (Set, error) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	if len(p.sets) == 0 {
-		return p.get()
+	if s, ok := p.take(); ok {
+		return s, nil
 	}
-
-	s := p.sets[0]
-	p.sets = p.sets[1:]
-	p.cvar.Signal()
-
-	return s, nil
+	return p.get()
 }
 
 // Put returns a set to the pool.  It is up to the caller to ensure the set is
@@ -93,46 +110,119 @@ func (p *Pool) Put(s Set) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	p.sets = append(p.sets, s)
+	p.items = append(p.items, s)
 }
 
 // Len shows how many sets are currently in the pool.
 func (p *Pool) Len() int {
-	p.mu.Lock()
-	defer p.mu.Unlock()
-	return len(p.sets)
+	return p.len()
 }
 
 // Run makes sure that the pool has at least `n` sets available.
 // Run spins up a new goroutine to maintain the pool.
+// When the pool runs low, the missing sets are created in a single batch (see `UnshareN`).
 // The goroutine will exit when the context is cancelled.
 //
 // The returned context will have an error set if the pool fails to create a set or is otherwise cancelled.
 func (p *Pool) Run(ctx context.Context, n int) (_ context.Context, cancel func()) {
+	return p.start(ctx, n)
+}
+
+// filler holds the items of a pool and keeps them topped up from a
+// goroutine started by `start`.
+// It is shared by `Pool` and `ExecutorPool`.
+type filler[T any] struct {
+	mu      sync.Mutex
+	cvar    *sync.Cond
+	items   []T
+	running bool
+
+	// create makes `n` new items, it is called with the lock held.
+	create func(ctx context.Context, n int) ([]T, error)
+	// close releases an item which is removed from the pool.
+	close func(T)
+
+	// notify is used for testing purposes
+	// it is called when items are created by `Run`
+	notify func()
+}
+
+func (f *filler[T]) init(create func(context.Context, int) ([]T, error), closeItem func(T)) {
+	f.cvar = sync.NewCond(&f.mu)
+	f.create = create
+	f.close = closeItem
+}
+
+// take removes the oldest item from the pool and wakes up the filler.
+// The lock must be held.
+func (f *filler[T]) take() (T, bool) {
+	var item T
+	if len(f.items) == 0 {
+		return item, false
+	}
+	item = f.items[0]
+	f.items = f.items[1:]
+	f.cvar.Signal()
+	return item, true
+}
+
+func (f *filler[T]) len() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.items)
+}
+
+// drain closes and removes all the items currently in the pool.
+func (f *filler[T]) drain() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, item := range f.items {
+		f.close(item)
+	}
+	f.items = nil
+}
+
+// start is the implementation of `Run` for the pools.
+func (f *filler[T]) start(ctx context.Context, n int) (_ context.Context, cancel func()) {
+	// Mark the pool as running before returning so items put back straight
+	// away are kept.
+	f.mu.Lock()
+	f.running = true
+	f.mu.Unlock()
+
 	ctx, cancelP := newPoolContext(ctx)
 	go func() {
-		cancelP(p.run(ctx, n))
+		cancelP(f.run(ctx, n))
+	}()
+	go func() {
+		// Wake up the filler so it can see the context is done.
+		<-ctx.Done()
+		f.mu.Lock()
+		f.cvar.Broadcast()
+		f.mu.Unlock()
 	}()
 	return ctx, func() {
 		cancelP(nil)
 	}
 }
 
-func (p *Pool) run(ctx context.Context, n int) error {
-	p.mu.Lock()
-	defer p.mu.Unlock()
+func (f *filler[T]) run(ctx context.Context, n int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
 
-	if p.sets == nil {
-		p.sets = make([]Set, 0, n)
+	if f.items == nil {
+		f.items = make([]T, 0, n)
 	}
 
 	defer func() {
-		for _, s := range p.sets {
-			s.Close()
+		f.running = false
+		for _, item := range f.items {
+			f.close(item)
 		}
-		p.sets = nil
-		if p.notify != nil {
-			p.notify()
+		f.items = nil
+		if f.notify != nil {
+			f.notify()
 		}
 	}()
 
@@ -143,8 +233,8 @@ func (p *Pool) run(ctx context.Context, n int) error {
 		default:
 		}
 
-		for len(p.sets) >= n {
-			p.cvar.Wait()
+		for len(f.items) >= n && ctx.Err() == nil {
+			f.cvar.Wait()
 		}
 
 		select {
@@ -153,13 +243,13 @@ func (p *Pool) run(ctx context.Context, n int) error {
 		default:
 		}
 
-		s, err := p.get()
+		items, err := f.create(ctx, n-len(f.items))
 		if err != nil {
 			return err
 		}
-		p.sets = append(p.sets, s)
-		if p.notify != nil {
-			p.notify()
+		f.items = append(f.items, items...)
+		if f.notify != nil {
+			f.notify()
 		}
 	}
 }
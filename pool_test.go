@@ -2,6 +2,7 @@ package gonso
 
 import (
 	"context"
+	"path/filepath"
 	"testing"
 	"time"
 )
@@ -60,7 +61,7 @@ func waitForPool(t *testing.T, ctx context.Context, p *Pool, n int) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	for len(p.sets) < n {
+	for len(p.items) < n {
 		select {
 		case <-ctx.Done():
 			t.Fatal(ctx.Err())
@@ -69,3 +70,57 @@ func waitForPool(t *testing.T, ctx context.Context, p *Pool, n int) {
 		p.cvar.Wait()
 	}
 }
+
+func TestPoolIDAllocator(t *testing.T) {
+	statePath := filepath.Join(t.TempDir(), "state.json")
+	a, err := NewIDAllocator(statePath, []SubIDRange{{Start: 100000, Count: 1000}}, []SubIDRange{{Start: 200000, Count: 1000}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	countAllocs := func() int {
+		var n int
+		updateState(statePath, func(state *idAllocState) error {
+			n = len(state.Allocations)
+			return nil
+		})
+		return n
+	}
+
+	p := NewPool(NS_USER|NS_NET, nil, a.WithIDMaps(100))
+
+	s, err := p.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := countAllocs(); n != 1 {
+		t.Fatalf("expected 1 allocation, got %d", n)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n := countAllocs(); n != 0 {
+		t.Fatalf("expected allocation to be released, got %d", n)
+	}
+
+	p.notify = func() {
+		p.cvar.Signal()
+	}
+
+	ctx, cancelP := p.Run(context.Background(), 3)
+	defer cancelP()
+
+	ctxT, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	waitForPool(t, ctxT, p, 3)
+
+	if n := countAllocs(); n != 3 {
+		t.Fatalf("expected 3 allocations, got %d", n)
+	}
+
+	// Draining the pool closes the sets which releases their allocations.
+	p.drain()
+	if n := countAllocs(); n != 0 {
+		t.Fatalf("expected allocations to be released, got %d", n)
+	}
+}
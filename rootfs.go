@@ -0,0 +1,351 @@
+package gonso
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+// RootFS is a view of the filesystem of a mount namespace which can be used
+// from any goroutine without joining the namespace.
+//
+// All paths are resolved relative to the root of the namespace, including
+// absolute symlinks and "..", so a symlink in the namespace can never be used
+// to reach a file outside of it.
+//
+// Names follow the conventions of `io/fs`: they are slash separated and
+// unrooted, e.g. "etc/hosts", with "." being the root itself.
+//
+// RootFS implements `fs.FS`, `fs.ReadDirFS`, `fs.ReadFileFS`, `fs.StatFS` and,
+// with Go 1.25+, `fs.ReadLinkFS`.
+type RootFS struct {
+	root *os.File
+}
+
+var (
+	_ fs.ReadDirFS  = &RootFS{}
+	_ fs.ReadFileFS = &RootFS{}
+	_ fs.StatFS     = &RootFS{}
+)
+
+// resolveFlags restricts path resolution to the root of the RootFS.
+// Magic links (e.g. /proc/self/root) are rejected since they can point anywhere.
+const resolveFlags = unix.RESOLVE_IN_ROOT | unix.RESOLVE_NO_MAGICLINKS
+
+// RootFS opens the root directory of the set's mount namespace.
+// The set must contain a mount namespace.
+//
+// The caller is responsible for calling `Close` on the returned RootFS.
+func (s Set) RootFS() (*RootFS, error) {
+	var (
+		fd  int
+		err error
+	)
+	if doErr := s.doMount(func() {
+		fd, err = openat2(unix.AT_FDCWD, "/", &unix.OpenHow{Flags: unix.O_PATH | unix.O_DIRECTORY})
+	}); doErr != nil {
+		return nil, doErr
+	}
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: "/", Err: err}
+	}
+	return &RootFS{root: os.NewFile(uintptr(fd), "/")}, nil
+}
+
+// Close releases the root directory.
+func (r *RootFS) Close() error {
+	return r.root.Close()
+}
+
+func (r *RootFS) openat(op, name string, flags int, perm fs.FileMode) (int, error) {
+	if !fs.ValidPath(name) {
+		return -1, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+
+	how := unix.OpenHow{Flags: uint64(flags), Resolve: resolveFlags}
+	// O_TMPFILE includes O_DIRECTORY so it must be checked as a whole.
+	if flags&unix.O_CREAT != 0 || flags&unix.O_TMPFILE == unix.O_TMPFILE {
+		how.Mode = uint64(syscallMode(perm))
+	}
+	fd, err := openat2(int(r.root.Fd()), name, &how)
+	if err != nil {
+		return -1, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	return fd, nil
+}
+
+// openParent opens the parent directory of `name` and returns it along with the last element of `name`.
+func (r *RootFS) openParent(op, name string) (*os.File, string, error) {
+	if !fs.ValidPath(name) || name == "." {
+		return nil, "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	dir, base := path.Split(name)
+	if dir == "" {
+		dir = "."
+	}
+	fd, err := r.openat(op, path.Clean(dir), unix.O_PATH|unix.O_DIRECTORY, 0)
+	if err != nil {
+		return nil, "", err
+	}
+	return os.NewFile(uintptr(fd), dir), base, nil
+}
+
+// Open opens the named file for reading.
+func (r *RootFS) Open(name string) (fs.File, error) {
+	f, err := r.OpenFile(name, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &rootFile{File: f, r: r, name: name}, nil
+}
+
+// rootFile makes sure directory entries are resolved through the RootFS
+// rather than relative to the caller's working directory.
+type rootFile struct {
+	*os.File
+	r    *RootFS
+	name string
+}
+
+func (f *rootFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	entries, err := f.File.ReadDir(n)
+	for i, e := range entries {
+		entries[i] = &rootDirEntry{DirEntry: e, r: f.r, name: path.Join(f.name, e.Name())}
+	}
+	return entries, err
+}
+
+type rootDirEntry struct {
+	fs.DirEntry
+	r    *RootFS
+	name string
+}
+
+func (e *rootDirEntry) Info() (fs.FileInfo, error) {
+	return e.r.Lstat(e.name)
+}
+
+// OpenFile is the equivalent of `os.OpenFile` for the RootFS.
+func (r *RootFS) OpenFile(name string, flag int, perm fs.FileMode) (*os.File, error) {
+	fd, err := r.openat("open", name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return os.NewFile(uintptr(fd), name), nil
+}
+
+// ReadDir reads the named directory and returns its entries sorted by filename.
+func (r *RootFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	f, err := r.OpenFile(name, os.O_RDONLY|unix.O_DIRECTORY, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	entries, err := (&rootFile{File: f, r: r, name: name}).ReadDir(-1)
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries, err
+}
+
+// ReadFile reads the named file and returns its contents.
+func (r *RootFS) ReadFile(name string) ([]byte, error) {
+	f, err := r.OpenFile(name, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+// Stat returns a FileInfo describing the named file, following symlinks.
+func (r *RootFS) Stat(name string) (fs.FileInfo, error) {
+	return r.stat("stat", name, unix.O_PATH)
+}
+
+// Lstat returns a FileInfo describing the named file without following a symlink in the last element.
+func (r *RootFS) Lstat(name string) (fs.FileInfo, error) {
+	return r.stat("lstat", name, unix.O_PATH|unix.O_NOFOLLOW)
+}
+
+func (r *RootFS) stat(op, name string, flags int) (fs.FileInfo, error) {
+	fd, err := r.openat(op, name, flags, 0)
+	if err != nil {
+		return nil, err
+	}
+	f := os.NewFile(uintptr(fd), name)
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	return fi, nil
+}
+
+// ReadLink returns the target of the named symlink.
+func (r *RootFS) ReadLink(name string) (string, error) {
+	fd, err := r.openat("readlink", name, unix.O_PATH|unix.O_NOFOLLOW, 0)
+	if err != nil {
+		return "", err
+	}
+	defer sys_close(fd)
+
+	for size := 128; ; size *= 2 {
+		buf := make([]byte, size)
+		n, err := unix.Readlinkat(fd, "", buf)
+		if err != nil {
+			return "", &fs.PathError{Op: "readlink", Path: name, Err: err}
+		}
+		if n < size {
+			return string(buf[:n]), nil
+		}
+	}
+}
+
+// WriteFile writes data to the named file, creating it if necessary.
+// If the file does not exist it is created with permissions `perm`, otherwise it is truncated.
+func (r *RootFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	f, err := r.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); closeErr != nil && err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Mkdir creates a new directory with the specified name and permission bits.
+func (r *RootFS) Mkdir(name string, perm fs.FileMode) error {
+	dir, base, err := r.openParent("mkdir", name)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	if err := unix.Mkdirat(int(dir.Fd()), base, syscallMode(perm)); err != nil {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: err}
+	}
+	return nil
+}
+
+// MkdirAll creates a directory named `name`, along with any necessary parents.
+// If the directory already exists, MkdirAll does nothing.
+func (r *RootFS) MkdirAll(name string, perm fs.FileMode) error {
+	if fi, err := r.Stat(name); err == nil {
+		if fi.IsDir() {
+			return nil
+		}
+		return &fs.PathError{Op: "mkdir", Path: name, Err: unix.ENOTDIR}
+	}
+
+	if dir := path.Dir(name); dir != "." {
+		if err := r.MkdirAll(dir, perm); err != nil {
+			return err
+		}
+	}
+
+	err := r.Mkdir(name, perm)
+	if err != nil {
+		// The directory may have been created in the meantime.
+		if fi, statErr := r.Lstat(name); statErr == nil && fi.IsDir() {
+			return nil
+		}
+	}
+	return err
+}
+
+// Remove removes the named file or empty directory.
+func (r *RootFS) Remove(name string) error {
+	dir, base, err := r.openParent("remove", name)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	err = unix.Unlinkat(int(dir.Fd()), base, 0)
+	if err == unix.EISDIR {
+		err = unix.Unlinkat(int(dir.Fd()), base, unix.AT_REMOVEDIR)
+	}
+	if err != nil {
+		return &fs.PathError{Op: "remove", Path: name, Err: err}
+	}
+	return nil
+}
+
+// Symlink creates `newname` as a symbolic link to `oldname`.
+// `oldname` is stored as is and is resolved relative to the root of the namespace when it is followed.
+func (r *RootFS) Symlink(oldname, newname string) error {
+	dir, base, err := r.openParent("symlink", newname)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	if err := unix.Symlinkat(oldname, int(dir.Fd()), base); err != nil {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: err}
+	}
+	return nil
+}
+
+// Chmod changes the mode of the named file, following symlinks.
+func (r *RootFS) Chmod(name string, mode fs.FileMode) error {
+	fd, err := r.openat("chmod", name, unix.O_PATH, 0)
+	if err != nil {
+		return err
+	}
+	defer sys_close(fd)
+
+	// fchmod(2) does not work on O_PATH file descriptors, go through procfs instead.
+	if err := unix.Chmod("/proc/self/fd/"+strconv.Itoa(fd), syscallMode(mode)); err != nil {
+		return &fs.PathError{Op: "chmod", Path: name, Err: err}
+	}
+	return nil
+}
+
+// Chown changes the numeric uid and gid of the named file, following symlinks.
+// The ids are as seen by the caller, see `Set.MapUID` for translating ids from a user namespace.
+func (r *RootFS) Chown(name string, uid, gid int) error {
+	return r.chown("chown", name, unix.O_PATH, uid, gid)
+}
+
+// Lchown is the same as `Chown` except it does not follow a symlink in the last element.
+func (r *RootFS) Lchown(name string, uid, gid int) error {
+	return r.chown("lchown", name, unix.O_PATH|unix.O_NOFOLLOW, uid, gid)
+}
+
+func (r *RootFS) chown(op, name string, flags, uid, gid int) error {
+	fd, err := r.openat(op, name, flags, 0)
+	if err != nil {
+		return err
+	}
+	defer sys_close(fd)
+
+	if err := unix.Fchownat(fd, "", uid, gid, unix.AT_EMPTY_PATH); err != nil {
+		return &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	return nil
+}
+
+// syscallMode converts a FileMode to the mode bits used by the kernel.
+func syscallMode(m fs.FileMode) uint32 {
+	mode := uint32(m.Perm())
+	if m&fs.ModeSetuid != 0 {
+		mode |= unix.S_ISUID
+	}
+	if m&fs.ModeSetgid != 0 {
+		mode |= unix.S_ISGID
+	}
+	if m&fs.ModeSticky != 0 {
+		mode |= unix.S_ISVTX
+	}
+	return mode
+}
@@ -0,0 +1,160 @@
+package gonso
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"testing/fstest"
+)
+
+func TestRootFS(t *testing.T) {
+	root := t.TempDir()
+	data := filepath.Join(root, "data")
+	if err := os.MkdirAll(filepath.Join(data, "dir"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(data, "hello"), []byte("world"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(data, "dir", "nested"), []byte("nested"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	// Absolute symlinks must be resolved relative to the namespace root.
+	if err := os.Symlink("/data/hello", filepath.Join(data, "abs")); err != nil {
+		t.Fatal(err)
+	}
+	// This would point at a file on the host if it were resolved with the caller's root.
+	if err := os.Mkdir(filepath.Join(root, "links"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("/etc/hostname", filepath.Join(root, "links", "escape")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("../../../../../../data/hello", filepath.Join(data, "dotdot")); err != nil {
+		t.Fatal(err)
+	}
+
+	set, err := Unshare(NS_MNT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer set.Close()
+
+	pivoted, err := set.PivotRoot(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pivoted.Close()
+
+	rootfs, err := pivoted.RootFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rootfs.Close()
+
+	sub, err := fs.Sub(rootfs, "data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fstest.TestFS(sub, "hello", "dir/nested", "abs", "dotdot"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"data/abs", "data/dotdot"} {
+		b, err := rootfs.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != "world" {
+			t.Errorf("%s: expected %q, got %q", name, "world", string(b))
+		}
+	}
+
+	if _, err := rootfs.ReadFile("links/escape"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected symlink to not escape the root, got: %v", err)
+	}
+	if _, err := rootfs.Open("/data/hello"); !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("expected invalid path error, got: %v", err)
+	}
+
+	target, err := rootfs.ReadLink("data/abs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target != "/data/hello" {
+		t.Errorf("unexpected symlink target: %q", target)
+	}
+	fi, err := rootfs.Lstat("data/abs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode()&fs.ModeSymlink == 0 {
+		t.Errorf("expected symlink, got %v", fi.Mode())
+	}
+
+	// Write helpers
+	if err := rootfs.MkdirAll("new/a/b", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := rootfs.MkdirAll("new/a/b", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := rootfs.WriteFile("new/a/b/file", []byte("new"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := rootfs.Chmod("new/a/b/file", 0o640); err != nil {
+		t.Fatal(err)
+	}
+	if err := rootfs.Chown("new/a/b/file", 1000, 1000); err != nil {
+		t.Fatal(err)
+	}
+	if err := rootfs.Symlink("/new/a/b/file", "new/link"); err != nil {
+		t.Fatal(err)
+	}
+
+	st, err := os.Stat(filepath.Join(root, "new/a/b/file"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Mode().Perm() != 0o640 {
+		t.Errorf("expected mode 0640, got %v", st.Mode())
+	}
+	if uid := st.Sys().(*syscall.Stat_t).Uid; uid != 1000 {
+		t.Errorf("expected uid 1000, got %d", uid)
+	}
+
+	b, err := rootfs.ReadFile("new/link")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "new" {
+		t.Errorf("unexpected content through symlink: %q", string(b))
+	}
+
+	if err := rootfs.Remove("new/link"); err != nil {
+		t.Fatal(err)
+	}
+	if err := rootfs.Remove("new/a/b/file"); err != nil {
+		t.Fatal(err)
+	}
+	if err := rootfs.Remove("new/a/b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "new/a/b")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected directory to be removed: %v", err)
+	}
+
+	// Creating files through an escaping symlink must stay inside the root.
+	if err := rootfs.Symlink("/../../outside", "links/outlink"); err != nil {
+		t.Fatal(err)
+	}
+	if err := rootfs.WriteFile("links/outlink", []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "outside")); err != nil {
+		t.Errorf("expected file to be created inside the root: %v", err)
+	}
+}
@@ -0,0 +1,202 @@
+package gonso
+
+import (
+	"fmt"
+	"net/netip"
+
+	"golang.org/x/sys/unix"
+)
+
+// Route is an entry in the main routing table of a network namespace.
+type Route struct {
+	// Dst is the destination of the route.
+	// When adding or deleting a route a zero value means the default route
+	// for the address family of `Gateway`.
+	Dst netip.Prefix
+	// Gateway is the next hop, if any.
+	Gateway netip.Addr
+	// Src is the preferred source address, if any.
+	Src netip.Addr
+	// Link is the name of the outgoing interface, if any.
+	Link string
+	// Metric is the priority of the route, lower is preferred.
+	Metric int
+}
+
+// RouteAdd adds `r` to the main routing table of the set's network namespace.
+// Either `r.Dst` or `r.Gateway` must be set, as well as either `r.Gateway` or `r.Link`.
+// The set must contain a network namespace.
+func (s Set) RouteAdd(r Route) error {
+	n, err := s.Netlink()
+	if err != nil {
+		return err
+	}
+	defer n.Close()
+	return n.RouteAdd(r)
+}
+
+// RouteDel removes `r` from the main routing table of the set's network namespace.
+// Fields which are not set match any route.
+// The set must contain a network namespace.
+func (s Set) RouteDel(r Route) error {
+	n, err := s.Netlink()
+	if err != nil {
+		return err
+	}
+	defer n.Close()
+	return n.RouteDel(r)
+}
+
+// RouteAdd adds `r` to the main routing table.
+// See `Set.RouteAdd`.
+func (n *Netlink) RouteAdd(r Route) error {
+	return n.routeChange(unix.RTM_NEWROUTE, unix.NLM_F_CREATE|unix.NLM_F_EXCL, r)
+}
+
+// RouteDel removes `r` from the main routing table.
+// See `Set.RouteDel`.
+func (n *Netlink) RouteDel(r Route) error {
+	return n.routeChange(unix.RTM_DELROUTE, 0, r)
+}
+
+func (n *Netlink) routeChange(typ, flags uint16, r Route) error {
+	dst := r.Dst
+	if !dst.IsValid() {
+		if !r.Gateway.IsValid() {
+			return fmt.Errorf("route needs a destination or gateway: %w", unix.EINVAL)
+		}
+		dst = netip.PrefixFrom(netip.IPv6Unspecified(), 0)
+		if r.Gateway.Unmap().Is4() {
+			dst = netip.PrefixFrom(netip.IPv4Unspecified(), 0)
+		}
+	}
+	dst = netip.PrefixFrom(dst.Addr().Unmap(), dst.Bits())
+	family := addrFamily(dst.Addr())
+
+	rtm := unix.RtMsg{
+		Family:  family,
+		Dst_len: uint8(dst.Bits()),
+		Table:   unix.RT_TABLE_MAIN,
+	}
+	if typ == unix.RTM_NEWROUTE {
+		rtm.Protocol = unix.RTPROT_BOOT
+		rtm.Type = unix.RTN_UNICAST
+		rtm.Scope = unix.RT_SCOPE_UNIVERSE
+		if !r.Gateway.IsValid() {
+			// Without a gateway the destination is directly reachable.
+			rtm.Scope = unix.RT_SCOPE_LINK
+		}
+	} else {
+		rtm.Scope = unix.RT_SCOPE_NOWHERE
+	}
+
+	c := n.c
+	var m netlinkMsg
+	netlinkHeader(&m, &rtm)
+	if dst.Bits() > 0 {
+		m.attr(unix.RTA_DST, dst.Masked().Addr().AsSlice())
+	}
+	for _, a := range []struct {
+		typ  uint16
+		addr netip.Addr
+	}{
+		{unix.RTA_GATEWAY, r.Gateway},
+		{unix.RTA_PREFSRC, r.Src},
+	} {
+		if !a.addr.IsValid() {
+			continue
+		}
+		addr := a.addr.Unmap()
+		if addrFamily(addr) != family {
+			return fmt.Errorf("address %s does not match the family of %s: %w", addr, dst, unix.EINVAL)
+		}
+		m.attr(a.typ, addr.AsSlice())
+	}
+	if r.Link != "" {
+		index, err := c.linkIndex(r.Link)
+		if err != nil {
+			return err
+		}
+		m.attrU32(unix.RTA_OIF, uint32(index))
+	}
+	if r.Metric > 0 {
+		m.attrU32(unix.RTA_PRIORITY, uint32(r.Metric))
+	}
+
+	if _, err := c.execute(typ, flags, m.b); err != nil {
+		op := "adding"
+		if typ == unix.RTM_DELROUTE {
+			op = "deleting"
+		}
+		return fmt.Errorf("error %s route to %s: %w", op, dst, err)
+	}
+	return nil
+}
+
+// RouteList returns the IPv4 and IPv6 unicast routes in the main routing
+// table of the set's network namespace, the same routes `ip route` shows.
+// The set must contain a network namespace.
+func (s Set) RouteList() ([]Route, error) {
+	n, err := s.Netlink()
+	if err != nil {
+		return nil, err
+	}
+	defer n.Close()
+	return n.RouteList()
+}
+
+// RouteList returns the IPv4 and IPv6 unicast routes in the main routing table.
+func (n *Netlink) RouteList() ([]Route, error) {
+	c := n.c
+	names, err := c.linkNames()
+	if err != nil {
+		return nil, err
+	}
+
+	var m netlinkMsg
+	netlinkHeader(&m, &unix.RtMsg{Family: unix.AF_UNSPEC})
+	msgs, err := c.execute(unix.RTM_GETROUTE, unix.NLM_F_DUMP, m.b)
+	if err != nil {
+		return nil, fmt.Errorf("error listing routes: %w", err)
+	}
+
+	var routes []Route
+	for _, msg := range msgs {
+		if msg.Header.Type != unix.RTM_NEWROUTE {
+			continue
+		}
+		rtm, rest, err := parseHeader[unix.RtMsg](msg.Data)
+		if err != nil {
+			return nil, err
+		}
+		attrs := parseAttrs(rest)
+
+		table := uint32(rtm.Table)
+		if b := attrs[unix.RTA_TABLE]; len(b) >= 4 {
+			table = nativeEndian.Uint32(b)
+		}
+		if table != unix.RT_TABLE_MAIN || rtm.Type != unix.RTN_UNICAST {
+			continue
+		}
+
+		var r Route
+		dst, ok := netip.AddrFromSlice(attrs[unix.RTA_DST])
+		if !ok {
+			dst = netip.IPv6Unspecified()
+			if rtm.Family == unix.AF_INET {
+				dst = netip.IPv4Unspecified()
+			}
+		}
+		r.Dst = netip.PrefixFrom(dst, int(rtm.Dst_len))
+		r.Gateway, _ = netip.AddrFromSlice(attrs[unix.RTA_GATEWAY])
+		r.Src, _ = netip.AddrFromSlice(attrs[unix.RTA_PREFSRC])
+		if b := attrs[unix.RTA_OIF]; len(b) >= 4 {
+			r.Link = names[int(nativeEndian.Uint32(b))]
+		}
+		if b := attrs[unix.RTA_PRIORITY]; len(b) >= 4 {
+			r.Metric = int(nativeEndian.Uint32(b))
+		}
+		routes = append(routes, r)
+	}
+	return routes, nil
+}
@@ -0,0 +1,97 @@
+package gonso
+
+import (
+	"errors"
+	"net/netip"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestRoute(t *testing.T) {
+	for name, flags := range map[string]int{"netns": NS_NET, "userns": NS_NET | NS_USER} {
+		flags := flags
+		t.Run(name, func(t *testing.T) {
+			set, err := Unshare(flags)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer set.Close()
+
+			if err := Veth(set, set, "veth0", "veth1", VethOpts{Up: true}); err != nil {
+				t.Fatal(err)
+			}
+			for _, p := range []string{"10.1.0.1/24", "fd00::1/64"} {
+				if err := set.AddrAdd("veth0", netip.MustParsePrefix(p)); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			routes := []Route{
+				{Gateway: netip.MustParseAddr("10.1.0.254")},
+				{Gateway: netip.MustParseAddr("fd00::fe"), Link: "veth0"},
+				{Dst: netip.MustParsePrefix("192.168.0.0/16"), Link: "veth0", Metric: 10},
+			}
+			for _, r := range routes {
+				if err := set.RouteAdd(r); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := set.RouteAdd(routes[0]); !errors.Is(err, unix.EEXIST) {
+				t.Errorf("expected EEXIST adding the same route twice, got: %v", err)
+			}
+			if err := set.RouteAdd(Route{Link: "veth0"}); !errors.Is(err, unix.EINVAL) {
+				t.Errorf("expected EINVAL without destination or gateway, got: %v", err)
+			}
+			if err := set.RouteAdd(Route{Dst: netip.MustParsePrefix("10.2.0.0/16"), Gateway: netip.MustParseAddr("fd00::fe")}); !errors.Is(err, unix.EINVAL) {
+				t.Errorf("expected EINVAL for mixed address families, got: %v", err)
+			}
+
+			expected := []Route{
+				{Dst: netip.MustParsePrefix("0.0.0.0/0"), Gateway: netip.MustParseAddr("10.1.0.254"), Link: "veth0"},
+				{Dst: netip.MustParsePrefix("::/0"), Gateway: netip.MustParseAddr("fd00::fe"), Link: "veth0"},
+				{Dst: netip.MustParsePrefix("192.168.0.0/16"), Link: "veth0", Metric: 10},
+				// Added by the kernel for the address on veth0.
+				{Dst: netip.MustParsePrefix("10.1.0.0/24"), Src: netip.MustParseAddr("10.1.0.1"), Link: "veth0"},
+			}
+
+			hasRoute := func(routes []Route, r Route) bool {
+				for _, rr := range routes {
+					// IPv6 routes get a default metric from the kernel.
+					if r.Metric == 0 && rr.Dst.Addr().Is6() {
+						rr.Metric = 0
+					}
+					if rr == r {
+						return true
+					}
+				}
+				return false
+			}
+
+			list, err := set.RouteList()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, r := range expected {
+				if !hasRoute(list, r) {
+					t.Errorf("expected route %+v, got: %+v", r, list)
+				}
+			}
+
+			for _, r := range routes {
+				if err := set.RouteDel(r); err != nil {
+					t.Fatal(err)
+				}
+			}
+			list, err = set.RouteList()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, r := range expected[:3] {
+				if hasRoute(list, r) {
+					t.Errorf("expected route %+v to be removed", r)
+				}
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package gonso
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
@@ -8,6 +9,7 @@ import (
 	"runtime"
 	"strconv"
 	"syscall"
+	"time"
 
 	"golang.org/x/sys/unix"
 )
@@ -23,16 +25,28 @@ type Set struct {
 	// fd type (e.g. CLONE_NEWNS) => fd
 	fds   map[nsFlag]int
 	flags int
+
+	// onClose holds resources tied to the lifetime of the set, e.g. id allocations.
+	// These are not carried over to sets created with `Dup`.
+	onClose []func() error
 }
 
 // Close closes all the file descriptors associated with the set.
 //
 // If this is the last reference to the file descriptors, the namespaces will be destroyed.
+// Any resources tied to the set (such as ids allocated with `IDAllocator.WithIDMaps`) are released.
 func (s Set) Close() error {
 	for _, fd := range s.fds {
 		sys_close(fd)
 	}
-	return nil
+
+	var retErr error
+	for _, f := range s.onClose {
+		if err := f(); err != nil && retErr == nil {
+			retErr = err
+		}
+	}
+	return retErr
 }
 
 // set sets the current thread to the namespaces in the set.
@@ -283,6 +297,300 @@ type UnshareConfig struct {
 	UidMaps []IDMap
 	// GidMappings is a list of gid mappings to use for the user namespace.
 	GidMaps []IDMap
+	// SetGroups controls whether setgroups(2) is permitted in the user namespace.
+	SetGroups SetGroups
+	// IDMapHelpers, when set, are used to write the uid and gid mappings
+	// instead of writing them directly.
+	IDMapHelpers *IDMapHelpers
+	// ChildTimeout is how long a child process used to create namespaces is
+	// allowed to live before it is killed.
+	// If this is 0, `DefaultChildTimeout` is used.
+	ChildTimeout time.Duration
+	// MountPropagation, when non-zero, is the propagation type (MS_PRIVATE,
+	// MS_SLAVE, MS_SHARED or MS_UNBINDABLE, optionally combined with MS_REC)
+	// applied to "/" in the new mount namespace.
+	MountPropagation int
+	// Loopback, when true, sets the loopback interface of the new network
+	// namespace up.
+	Loopback bool
+
+	// err is set by options which can fail
+	err error
+	// beforeCreate is called before each set is created, e.g. to allocate
+	// resources for the set. The returned function, if any, is added to onClose.
+	beforeCreate []func(*UnshareConfig) (func() error, error)
+	// onClose is attached to the new set, or called if creating the set fails.
+	onClose []func() error
+	// afterCreate is called with each new set before it is returned.
+	// The returned function, if any, is attached to the set's onClose.
+	afterCreate []func(Set) (func() error, error)
+}
+
+// DefaultChildTimeout is the default value for `UnshareConfig.ChildTimeout`.
+const DefaultChildTimeout = 5 * time.Second
+
+func (c UnshareConfig) childTimeout() time.Duration {
+	if c.ChildTimeout <= 0 {
+		return DefaultChildTimeout
+	}
+	return c.ChildTimeout
+}
+
+// WithChildTimeout sets how long a child process used to create namespaces is allowed to live before it is killed.
+// It can be used as an UnshareOpt to configure the Unshare function.
+func WithChildTimeout(d time.Duration) UnshareOpt {
+	return func(c *UnshareConfig) {
+		c.ChildTimeout = d
+	}
+}
+
+// UnshareStage describes what a child process used to create namespaces was doing.
+type UnshareStage string
+
+const (
+	// StagePipe is when the pipe used to control the child is being created.
+	StagePipe UnshareStage = "create pipe"
+	// StageClone is when the child is being created.
+	StageClone UnshareStage = "clone"
+	// StageIDMaps is when the uid and gid mappings are being written for the child.
+	StageIDMaps UnshareStage = "write id maps"
+	// StageNamespaces is when the namespaces of the child are being opened.
+	StageNamespaces UnshareStage = "open namespaces"
+	// StageChildSetup is when the child is setting up the new namespaces, e.g.
+	// changing the mount propagation.
+	StageChildSetup UnshareStage = "child setup"
+	// StageWait is when waiting for the child to exit.
+	StageWait UnshareStage = "wait"
+	// StageSetup is after the child is done, while the new set is being set
+	// up, e.g. bringing up the loopback interface.
+	StageSetup UnshareStage = "set setup"
+)
+
+// ChildTimeoutError is returned when the context passed to `UnshareContext`
+// is done, or the child timeout expires, while a child process used to create
+// namespaces is still in use.
+type ChildTimeoutError struct {
+	// Pid is the pid of the child process.
+	// This is 0 if the child had not been created yet or is already gone.
+	Pid int
+	// Stage is what the child was doing at the time.
+	Stage UnshareStage
+	// Err is the error from the context.
+	Err error
+}
+
+func (e *ChildTimeoutError) Error() string {
+	what := "timeout"
+	if errors.Is(e.Err, context.Canceled) {
+		what = "cancelled"
+	}
+	if e.Pid == 0 {
+		return fmt.Sprintf("%s during %s: %v", what, e.Stage, e.Err)
+	}
+	return fmt.Sprintf("%s waiting on child %d during %s: %v", what, e.Pid, e.Stage, e.Err)
+}
+
+func (e *ChildTimeoutError) Unwrap() error {
+	return e.Err
+}
+
+func newUnshareConfig(flags int, opts []UnshareOpt) (UnshareConfig, error) {
+	var cfg UnshareConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	if err := cfg.validate(flags); err != nil {
+		cfg.close()
+		return UnshareConfig{}, err
+	}
+	return cfg, nil
+}
+
+func (c UnshareConfig) validate(flags int) error {
+	if c.err != nil {
+		return c.err
+	}
+
+	if (len(c.UidMaps) > 0 || len(c.GidMaps) > 0) && flags&unix.CLONE_NEWUSER == 0 {
+		// Only setting the idmaps when creating the userns is first created supported.
+		return fmt.Errorf("id maps specified but CLONE_NEWUSER not in flags: %w", unix.EINVAL)
+	}
+	if c.SetGroups != SetGroupsDefault && flags&unix.CLONE_NEWUSER == 0 {
+		return fmt.Errorf("setgroups specified but CLONE_NEWUSER not in flags: %w", unix.EINVAL)
+	}
+
+	if c.MountPropagation != 0 {
+		if flags&unix.CLONE_NEWNS == 0 {
+			return fmt.Errorf("mount propagation specified but CLONE_NEWNS not in flags: %w", unix.EINVAL)
+		}
+		switch c.MountPropagation &^ unix.MS_REC {
+		case unix.MS_PRIVATE, unix.MS_SLAVE, unix.MS_SHARED, unix.MS_UNBINDABLE:
+		default:
+			return fmt.Errorf("invalid mount propagation %#x: %w", c.MountPropagation, unix.EINVAL)
+		}
+	}
+
+	if c.Loopback && flags&unix.CLONE_NEWNET == 0 {
+		return fmt.Errorf("loopback specified but CLONE_NEWNET not in flags: %w", unix.EINVAL)
+	}
+
+	if err := validateIDMaps(c.UidMaps); err != nil {
+		return fmt.Errorf("invalid uid maps: %w", err)
+	}
+	if err := validateIDMaps(c.GidMaps); err != nil {
+		return fmt.Errorf("invalid gid maps: %w", err)
+	}
+	return nil
+}
+
+// setMountPropagation applies the configured mount propagation to "/" in the
+// mount namespace of the current thread.
+func (c UnshareConfig) setMountPropagation() error {
+	if c.MountPropagation == 0 {
+		return nil
+	}
+	if err := mountPropagation("/", c.MountPropagation); err != nil {
+		return fmt.Errorf("error setting mount propagation: %w", err)
+	}
+	return nil
+}
+
+// prepare runs the beforeCreate hooks for a set which is about to be created.
+// On error any resources acquired by the hooks are released.
+func (c *UnshareConfig) prepare(flags int) error {
+	for _, f := range c.beforeCreate {
+		release, err := f(c)
+		if release != nil {
+			c.onClose = append(c.onClose, release)
+		}
+		if err != nil {
+			c.close()
+			return err
+		}
+	}
+	if len(c.beforeCreate) == 0 {
+		return nil
+	}
+	// The hooks may have changed the config.
+	if err := c.validate(flags); err != nil {
+		c.close()
+		return err
+	}
+	return nil
+}
+
+// setup configures a newly created set.
+// The returned functions release resources tied to the set and must be added to its onClose.
+func (c UnshareConfig) setup(s Set) (cleanup []func() error, retErr error) {
+	defer func() {
+		if retErr != nil {
+			for _, f := range cleanup {
+				f()
+			}
+			cleanup = nil
+		}
+	}()
+
+	if c.Loopback {
+		if err := s.LinkUp("lo"); err != nil {
+			return nil, err
+		}
+	}
+	for _, f := range c.afterCreate {
+		release, err := f(s)
+		if err != nil {
+			return cleanup, err
+		}
+		if release != nil {
+			cleanup = append(cleanup, release)
+		}
+	}
+	return cleanup, nil
+}
+
+// close releases any resources held by the config.
+func (c UnshareConfig) close() {
+	for _, f := range c.onClose {
+		f()
+	}
+}
+
+// setGroups returns the value to write to /proc/<pid>/setgroups for a new
+// user namespace, or an empty string if nothing should be written.
+func (c UnshareConfig) setGroups() string {
+	switch c.SetGroups {
+	case SetGroupsAllow:
+		return "allow"
+	case SetGroupsDeny:
+		return "deny"
+	default:
+		// Without CAP_SETGID in the parent user namespace the kernel refuses
+		// to write gid_map unless setgroups(2) is denied.
+		// When helpers are used they are privileged and take care of this themselves.
+		if len(c.GidMaps) > 0 && c.IDMapHelpers == nil && !hasCap(unix.CAP_SETGID) {
+			return "deny"
+		}
+		return ""
+	}
+}
+
+// SetGroups controls whether setgroups(2) is permitted in a new user namespace.
+// See user_namespaces(7) for details on /proc/<pid>/setgroups.
+type SetGroups int
+
+const (
+	// SetGroupsDefault denies setgroups(2) when the caller is unprivileged
+	// (i.e. it does not have CAP_SETGID) and gid mappings are specified,
+	// otherwise the kernel default (allow) is used.
+	SetGroupsDefault SetGroups = iota
+	// SetGroupsAllow permits setgroups(2) in the new user namespace.
+	// Unprivileged callers will not be able to write gid mappings with this set.
+	SetGroupsAllow
+	// SetGroupsDeny denies setgroups(2) in the new user namespace.
+	SetGroupsDeny
+)
+
+// WithSetGroups sets whether setgroups(2) is permitted in the new user namespace.
+// It can be used as an UnshareOpt to configure the Unshare function.
+func WithSetGroups(allow bool) UnshareOpt {
+	return func(c *UnshareConfig) {
+		if allow {
+			c.SetGroups = SetGroupsAllow
+		} else {
+			c.SetGroups = SetGroupsDeny
+		}
+	}
+}
+
+// WithMountPropagation sets the propagation type of "/" in the new mount namespace.
+// `propagation` must be one of MS_PRIVATE, MS_SLAVE, MS_SHARED or
+// MS_UNBINDABLE. If `recursive` is true the propagation type is applied to
+// every mount in the namespace.
+//
+// A new mount namespace is a copy of the one it was created from, including
+// shared propagation, so without this mounts made in the new namespace may
+// propagate back to the original namespace (and vice versa).
+//
+// The propagation is changed before `Unshare` returns.
+// It can be used as an UnshareOpt to configure the Unshare function.
+func WithMountPropagation(propagation int, recursive bool) UnshareOpt {
+	return func(c *UnshareConfig) {
+		c.MountPropagation = propagation
+		if recursive {
+			c.MountPropagation |= unix.MS_REC
+		}
+	}
+}
+
+// WithLoopback sets the loopback interface ("lo") of the new network namespace
+// up before `Unshare` returns. A new network namespace starts with loopback down,
+// so nothing can connect to 127.0.0.1 without this.
+// It can be used as an UnshareOpt to configure the Unshare function.
+func WithLoopback() UnshareOpt {
+	return func(c *UnshareConfig) {
+		c.Loopback = true
+	}
 }
 
 // WithIDMaps sets the uid and gid mappings to use for the user namespace.
@@ -304,6 +612,18 @@ func WithIDMaps(uidMaps, gidMaps []IDMap) UnshareOpt {
 // The forked process is used to create the user namespace and any other namespaces specified in `flags`.
 // You can use `Do` by calling `Dup` on the set and dropping CLONE_NEWUSER from the flags.
 func (s Set) Unshare(flags int, opts ...UnshareOpt) (Set, error) {
+	return s.UnshareContext(context.Background(), flags, opts...)
+}
+
+// UnshareContext is the same as `Unshare` but honors the deadline and cancellation of the passed in context.
+//
+// When a child process is needed to create the namespaces (i.e. when a user
+// namespace is involved) the child is killed if the context is done before it
+// exits, and a `*ChildTimeoutError` is returned describing what the child was
+// doing at the time. If the context is done after the child has finished its
+// Stage is `StageSetup`.
+// When no child is needed the error from the context is returned as is.
+func (s Set) UnshareContext(ctx context.Context, flags int, opts ...UnshareOpt) (Set, error) {
 	type result struct {
 		s   Set
 		err error
@@ -311,14 +631,12 @@ func (s Set) Unshare(flags int, opts ...UnshareOpt) (Set, error) {
 
 	restore := restorable(flags)
 
-	var cfg UnshareConfig
-	for _, opt := range opts {
-		opt(&cfg)
+	cfg, err := newUnshareConfig(flags, opts)
+	if err != nil {
+		return Set{}, err
 	}
-
-	if (len(cfg.UidMaps) > 0 || len(cfg.GidMaps) > 0) && flags&unix.CLONE_NEWUSER == 0 {
-		// Only setting the idmaps when creating the userns is first created supported.
-		return Set{}, fmt.Errorf("id maps specified but CLONE_NEWUSER not in flags: %w", unix.EINVAL)
+	if err := cfg.prepare(flags); err != nil {
+		return Set{}, err
 	}
 
 	ch := make(chan result)
@@ -329,7 +647,7 @@ func (s Set) Unshare(flags int, opts ...UnshareOpt) (Set, error) {
 				// If we are creating a new user namespace, we need to fork a new process
 				// If the Set already contains a user namespace and we are not creating a new one, then we also need to join the user namespace before creating the new namespaces.
 				// This ensures the new namespaces are bouond to the user namespace.
-				newS, err := cloneNs(s, flags, cfg.UidMaps, cfg.GidMaps)
+				newS, err := cloneNs(ctx, s, flags, cfg)
 				if err != nil {
 					return Set{}, err
 				}
@@ -340,6 +658,10 @@ func (s Set) Unshare(flags int, opts ...UnshareOpt) (Set, error) {
 				return newS, nil
 			}
 
+			if err := ctx.Err(); err != nil {
+				return Set{}, err
+			}
+
 			runtime.LockOSThread()
 
 			defer func() {
@@ -353,6 +675,9 @@ func (s Set) Unshare(flags int, opts ...UnshareOpt) (Set, error) {
 			if err := unshare(flags); err != nil {
 				return Set{}, fmt.Errorf("error unsharing namespaces: %w", err)
 			}
+			if err := cfg.setMountPropagation(); err != nil {
+				return Set{}, err
+			}
 
 			newS, err := curNamespaces(flags)
 			if err != nil {
@@ -372,19 +697,247 @@ func (s Set) Unshare(flags int, opts ...UnshareOpt) (Set, error) {
 	}()
 
 	r := <-ch
-	return r.s, r.err
+	if r.err != nil {
+		cfg.close()
+		return Set{}, r.err
+	}
+	if err := setupCtxErr(ctx, s, flags); err != nil {
+		r.s.Close()
+		cfg.close()
+		return Set{}, err
+	}
+	cleanup, err := cfg.setup(r.s)
+	if err != nil {
+		r.s.Close()
+		cfg.close()
+		return Set{}, err
+	}
+	r.s.onClose = append(r.s.onClose, cfg.onClose...)
+	r.s.onClose = append(r.s.onClose, cleanup...)
+	return r.s, nil
+}
+
+// UnshareN is the same as `Unshare` except it creates `n` new sets at once.
+//
+// What is shared across the batch is the setup around creating each set:
+// a single OS thread is locked and switched into the set's namespaces once
+// for the whole batch rather than once per set.
+//
+// When the set already has a user namespace, and `flags` does not contain
+// CLONE_NEWUSER or CLONE_NEWPID, a single helper process joins the user
+// namespace and repeatedly unshares the namespaces, with each set being
+// collected from the helper before it moves on to the next one.
+//
+// When creating new user namespaces there is still one child process per set.
+// A process which has created a user namespace has no privileges left in its
+// parent user namespace, so one helper can't create sibling user namespaces.
+// Likewise a pid namespace can only be unshared once per process. In those
+// cases each child writes its own id maps and has its namespaces opened; the
+// children are forked from the same thread, share a single control pipe and
+// are waited on concurrently under one child timeout, rather than each set
+// paying for a separate fork/wait round-trip in sequence.
+//
+// On error, any sets that were already created are closed.
+func (s Set) UnshareN(flags, n int, opts ...UnshareOpt) ([]Set, error) {
+	return s.UnshareNContext(context.Background(), flags, n, opts...)
+}
+
+// UnshareNContext is the same as `UnshareN` but honors the deadline and cancellation of the passed in context.
+// See `Set.UnshareContext` for details.
+func (s Set) UnshareNContext(ctx context.Context, flags, n int, opts ...UnshareOpt) ([]Set, error) {
+	type result struct {
+		sets []Set
+		err  error
+	}
+
+	if n <= 0 {
+		return nil, nil
+	}
+
+	restore := restorable(flags)
+
+	cfg, err := newUnshareConfig(flags, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(cfg.beforeCreate) > 0 {
+		// The options acquire resources which can't be shared between sets so
+		// each set must be prepared separately.
+		return s.unshareEach(ctx, flags, n, opts...)
+	}
+
+	ch := make(chan result)
+	go func() {
+		sets, err := func() (retSets []Set, retErr error) {
+			if flags&unix.CLONE_NEWUSER != 0 || s.flags&unix.CLONE_NEWUSER != 0 {
+				// See `Unshare` for why a new process is needed here.
+				sets, err := cloneNsN(ctx, s, flags, n, cfg)
+				if err != nil {
+					return nil, err
+				}
+				for i := range sets {
+					if err := merge(s, &sets[i]); err != nil {
+						for _, s := range sets {
+							s.Close()
+						}
+						return nil, err
+					}
+				}
+				return sets, nil
+			}
+
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+
+			runtime.LockOSThread()
+
+			defer func() {
+				// Only unlock this thread if there are no errors.
+				// Additionally should not unlock threads that have had non-reversiable changes made to them.
+				if retErr == nil && restore {
+					runtime.UnlockOSThread()
+				}
+			}()
+
+			// Keep track of the namespaces we started with so each new set is
+			// unshared from the same place rather than from the previous set.
+			base, err := curNamespaces(flags)
+			if err != nil {
+				return nil, fmt.Errorf("error getting namespaces: %w", err)
+			}
+			defer base.Close()
+
+			sets := make([]Set, 0, n)
+			defer func() {
+				if retErr != nil {
+					for _, s := range sets {
+						s.Close()
+					}
+				}
+			}()
+
+			for i := 0; i < n; i++ {
+				if err := ctx.Err(); err != nil {
+					return nil, err
+				}
+				if i > 0 {
+					if err := base.set(false); err != nil {
+						return nil, err
+					}
+				}
+
+				if err := unshare(flags); err != nil {
+					return nil, fmt.Errorf("error unsharing namespaces: %w", err)
+				}
+				if err := cfg.setMountPropagation(); err != nil {
+					return nil, err
+				}
+
+				newS, err := curNamespaces(flags)
+				if err != nil {
+					return nil, fmt.Errorf("error getting namespaces: %w", err)
+				}
+				sets = append(sets, newS)
+			}
+
+			// Try to restore this thread so it can be re-used be go.
+			if restore {
+				if err := s.set(false); err != nil {
+					return nil, err
+				}
+			}
+
+			return sets, nil
+		}()
+		ch <- result{sets: sets, err: err}
+	}()
+
+	r := <-ch
+	if r.err != nil {
+		return nil, r.err
+	}
+	for i := range r.sets {
+		err := setupCtxErr(ctx, s, flags)
+		var cleanup []func() error
+		if err == nil {
+			cleanup, err = cfg.setup(r.sets[i])
+		}
+		if err != nil {
+			for _, s := range r.sets {
+				s.Close()
+			}
+			return nil, err
+		}
+		r.sets[i].onClose = append(r.sets[i].onClose, cleanup...)
+	}
+	return r.sets, nil
+}
+
+// setupCtxErr checks the context once the namespaces for a set have been
+// created, before the set is set up.
+func setupCtxErr(ctx context.Context, s Set, flags int) error {
+	err := ctx.Err()
+	if err == nil {
+		return nil
+	}
+	if flags&unix.CLONE_NEWUSER != 0 || s.flags&unix.CLONE_NEWUSER != 0 {
+		// Consistent with errors from while the child was still around.
+		return &ChildTimeoutError{Stage: StageSetup, Err: err}
+	}
+	return err
+}
+
+// unshareEach creates `n` sets one at a time.
+func (s Set) unshareEach(ctx context.Context, flags, n int, opts ...UnshareOpt) ([]Set, error) {
+	sets := make([]Set, 0, n)
+	for i := 0; i < n; i++ {
+		newS, err := s.UnshareContext(ctx, flags, opts...)
+		if err != nil {
+			for _, s := range sets {
+				s.Close()
+			}
+			return nil, err
+		}
+		sets = append(sets, newS)
+	}
+	return sets, nil
+}
+
+// UnshareN returns `n` new sets with the namespaces specified in `flags` unshared.
+// This is the same as calling `Current(flags).UnshareN(flags, n)`.
+func UnshareN(flags, n int, opts ...UnshareOpt) ([]Set, error) {
+	return UnshareNContext(context.Background(), flags, n, opts...)
+}
+
+// UnshareNContext is the same as `UnshareN` but honors the deadline and cancellation of the passed in context.
+// See `Set.UnshareContext` for details.
+func UnshareNContext(ctx context.Context, flags, n int, opts ...UnshareOpt) ([]Set, error) {
+	s, err := Current(flags)
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+	return s.UnshareNContext(ctx, flags, n, opts...)
 }
 
 // Unshare returns a new `Set` with the namespaces specified in `flags` unshared (i.e. new namespaces are created).
 // The returned set only contains the namespaces specified in `flags`.
 // This is the same as calling `Current(flags).Unshare(flags)`.
 func Unshare(flags int, opts ...UnshareOpt) (Set, error) {
+	return UnshareContext(context.Background(), flags, opts...)
+}
+
+// UnshareContext is the same as `Unshare` but honors the deadline and cancellation of the passed in context.
+// See `Set.UnshareContext` for details.
+func UnshareContext(ctx context.Context, flags int, opts ...UnshareOpt) (Set, error) {
 	s, err := Current(flags)
 	if err != nil {
 		return Set{}, err
 	}
 	defer s.Close()
-	return s.Unshare(flags, opts...)
+	return s.UnshareContext(ctx, flags, opts...)
 }
 
 // Mount the set's namespaces to the specified target directory with each
@@ -400,6 +953,7 @@ func Unshare(flags int, opts ...UnshareOpt) (Set, error) {
 //
 // Mounting a mount namespace is also tricky see the mount(2) documentation for details.
 // In particular, mounting a mount namespace magic link may cause EINVAL if the parent uses MS_SHARED.
+// `WithMountPropagation` can be used to make a new mount namespace private when it is created.
 func (s Set) Mount(target string) error {
 	var err error
 
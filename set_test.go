@@ -5,6 +5,7 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"testing"
 
 	"golang.org/x/sys/unix"
@@ -206,3 +207,24 @@ func (s Set) testGetID(t *testing.T, ns int) string {
 	}
 	return id
 }
+
+// nsGetUserns is the NS_GET_USERNS ioctl, see ioctl_ns(2).
+const nsGetUserns = 0xb701
+
+// testGetOwnerID returns the ID of the user namespace which owns the namespace
+// of type `ns` in the set.
+func (s Set) testGetOwnerID(t *testing.T, ns int) string {
+	t.Helper()
+
+	fd, err := unix.IoctlRetInt(s.fds[ns], nsGetUserns)
+	if err != nil {
+		t.Fatalf("error getting owner of %s: %v", nsFlagsReverse[ns], err)
+	}
+	defer unix.Close(fd)
+
+	id, err := os.Readlink("/proc/self/fd/" + strconv.Itoa(fd))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
@@ -1,11 +1,11 @@
 package gonso
 
 import (
-	"bytes"
 	"context"
+	"errors"
 	"fmt"
-	"io"
 	"os"
+	"path/filepath"
 	"runtime"
 	"syscall"
 	"time"
@@ -24,14 +24,65 @@ import (
 //
 // This function is useful for operating on or collecting the namespace fd's of the child process.
 func doClone(s Set, flags, pipeFd int) (pid int, _ error) {
+	if err := s.set(true); err != nil {
+		return 0, err
+	}
+	return clone(s, flags, pipeFd, nil)
+}
+
+// clone is the same as doClone except it expects that the current thread is
+// already set to the namespaces in the Set (except for the user namespace).
+// This allows multiple children to be created without setting the namespaces each time.
+//
+// When the Set contains a user namespace and `flags` does not contain
+// CLONE_NEWUSER the child joins the user namespace first and then unshares
+// the namespaces in `flags` itself, so the new namespaces are owned by the
+// set's user namespace rather than by the user namespace of the caller.
+// Such a child is not a member of a new pid or time namespace, use
+// `childNamespaces` to collect its namespaces.
+//
+// If `setup` is not nil the child performs the extra setup described by it
+// before blocking on `pipeFd`.
+// When `setup.count` is more than 1 the child unshares the namespaces again
+// each time a byte is written to `pipeFd`, reporting on `setup.statusFd` each
+// time, so one child can be used to create several sets of namespaces.
+func clone(s Set, flags, pipeFd int, setup *childSetup) (pid int, _ error) {
 	buf := make([]byte, 1)
 	_p0 := unsafe.Pointer(&buf[0])
 
-	if err := s.set(true); err != nil {
-		return 0, err
+	// The child cannot allocate so everything it needs must be prepared before forking.
+	var (
+		propagation uintptr
+		root        unsafe.Pointer
+		statusFd    = -1
+		count       = 1
+		status      = make([]byte, 1)
+		_p1         = unsafe.Pointer(&status[0])
+	)
+	if setup != nil {
+		statusFd = setup.statusFd
+		if setup.count > 1 {
+			if flags&(unix.CLONE_NEWUSER|unix.CLONE_NEWPID) != 0 {
+				// Neither can be unshared more than once by the same process.
+				return 0, fmt.Errorf("cannot create more than one set per child with flags %#x: %w", flags, unix.EINVAL)
+			}
+			count = setup.count
+		}
+		propagation = uintptr(setup.propagation)
+		if propagation != 0 {
+			p, err := unix.BytePtrFromString("/")
+			if err != nil {
+				return 0, err
+			}
+			root = unsafe.Pointer(p)
+		}
 	}
 
-	var usernsFd int
+	var (
+		usernsFd     int
+		cloneFlags   = flags
+		unshareFlags uintptr
+	)
 	// If `flags` contains CLONE_NEWUSER then the call to clone will create a
 	// new usernamespace. We don't want to try and set any user namespace from
 	// the `Set` in this case because we want the new userns not the old one.
@@ -41,12 +92,18 @@ func doClone(s Set, flags, pipeFd int) (pid int, _ error) {
 	if flags&unix.CLONE_NEWUSER == 0 {
 		usernsFd = s.fds[unix.CLONE_NEWUSER]
 	}
+	if usernsFd > 0 {
+		// Namespaces created by clone are owned by the user namespace of the
+		// caller, the child has to be in the set's user namespace first.
+		cloneFlags = 0
+		unshareFlags = uintptr(flags)
+	}
 
 	// Handle overflow of untyped int on 32-bit platforms
 	clearSigHand := int64(unix.CLONE_CLEAR_SIGHAND)
 
 	beforeFork()
-	pidptr, _, errno := unix.RawSyscall6(unix.SYS_CLONE, uintptr(unix.SIGCHLD)|uintptr(clearSigHand)|unix.CLONE_FILES|uintptr(flags), 0, 0, 0, 0, 0)
+	pidptr, _, errno := unix.RawSyscall6(unix.SYS_CLONE, uintptr(unix.SIGCHLD)|uintptr(clearSigHand)|unix.CLONE_FILES|uintptr(cloneFlags), 0, 0, 0, 0, 0)
 	if errno != 0 {
 		afterFork()
 		return 0, fmt.Errorf("error calling clone: %w", errno)
@@ -59,23 +116,117 @@ func doClone(s Set, flags, pipeFd int) (pid int, _ error) {
 	}
 
 	// child process
+
 	if usernsFd > 0 {
-		_, _, errno := unix.RawSyscall(unix.SYS_SETNS, uintptr(usernsFd), uintptr(unix.CLONE_NEWUSER), 0)
+		_, _, errno = unix.RawSyscall(unix.SYS_SETNS, uintptr(usernsFd), uintptr(unix.CLONE_NEWUSER), 0)
 		if errno != 0 {
-			unix.RawSyscall(unix.SYS_EXIT_GROUP, uintptr(errno), 0, 0)
-			panic("unreachable")
+			goto fail
+		}
+	}
+
+	for i := 0; i < count; i++ {
+		if i > 0 {
+			// Wait for the parent to collect the previous namespaces.
+			n, _, _ := unix.RawSyscall(unix.SYS_READ, uintptr(pipeFd), uintptr(_p0), uintptr(len(buf)))
+			if n != 1 {
+				unix.RawSyscall(unix.SYS_EXIT_GROUP, uintptr(0), 0, 0)
+				panic("unreachable")
+			}
+		}
+
+		if unshareFlags != 0 {
+			_, _, errno = unix.RawSyscall(unix.SYS_UNSHARE, unshareFlags, 0, 0)
+			if errno != 0 {
+				goto fail
+			}
+		}
+
+		if unshareFlags&unix.CLONE_NEWPID != 0 {
+			// The new pid namespace can't be opened until it has an init process.
+			pidptr, _, errno = unix.RawSyscall6(unix.SYS_CLONE, uintptr(unix.SIGCHLD)|unix.CLONE_FILES, 0, 0, 0, 0, 0)
+			if errno != 0 {
+				goto fail
+			}
+			if pidptr == 0 {
+				unix.RawSyscall(unix.SYS_READ, uintptr(pipeFd), uintptr(_p0), uintptr(len(buf)))
+				unix.RawSyscall(unix.SYS_EXIT_GROUP, uintptr(0), 0, 0)
+				panic("unreachable")
+			}
+		}
+
+		if propagation != 0 {
+			_, _, errno = unix.RawSyscall6(unix.SYS_MOUNT, 0, uintptr(root), 0, propagation, 0, 0)
+			if errno != 0 {
+				goto fail
+			}
+		}
+
+		if statusFd >= 0 {
+			unix.RawSyscall(unix.SYS_WRITE, uintptr(statusFd), uintptr(_p1), 1)
 		}
 	}
 
 	// block until the parent process closes this fd
 	unix.RawSyscall(unix.SYS_READ, uintptr(pipeFd), uintptr(_p0), uintptr(len(buf)))
+	if unshareFlags&unix.CLONE_NEWPID != 0 {
+		// Reap the init of the pid namespace, it exits on the same pipe.
+		unix.RawSyscall6(unix.SYS_WAIT4, pidptr, 0, 0, 0, 0, 0)
+	}
 	unix.RawSyscall(unix.SYS_EXIT_GROUP, uintptr(0), 0, 0)
 	panic("unreachable")
+
+fail:
+	if statusFd >= 0 {
+		status[0] = byte(errno)
+		unix.RawSyscall(unix.SYS_WRITE, uintptr(statusFd), uintptr(_p1), 1)
+	}
+	unix.RawSyscall(unix.SYS_EXIT_GROUP, uintptr(errno), 0, 0)
+	panic("unreachable")
+}
+
+// childSetup describes extra work for a child created by `clone` to do before
+// it blocks.
+type childSetup struct {
+	// propagation is the mount propagation to apply to "/", if non-zero.
+	propagation int
+	// statusFd is the write end of a pipe the child writes a single byte to
+	// once it is done with its setup. The byte is 0 on success or the errno
+	// of the failed operation.
+	statusFd int
+	// count is the number of times the child creates the namespaces.
+	// Values less than 2 mean once.
+	count int
 }
 
-func cloneNs(s Set, flags int, uidMaps, gidMaps []IDMap) (Set, error) {
+func cloneNs(ctx context.Context, s Set, flags int, cfg UnshareConfig) (Set, error) {
+	sets, err := cloneNsN(ctx, s, flags, 1, cfg)
+	if err != nil {
+		return Set{}, err
+	}
+	return sets[0], nil
+}
+
+// cloneNsN creates `n` sets with the namespaces in `flags` using child
+// processes and collects the namespaces of each into a new Set.
+//
+// Where possible a single child creates all the sets, see `setsPerChild`: it
+// unshares the namespaces, reports back on the status pipe and waits for a
+// byte on the control pipe, which is written once the namespaces have been
+// opened, before unsharing the next set.
+// Otherwise there is one child per set. All the children are created from the
+// same thread and block on the same pipe so the cost of setting up the
+// thread, and of waiting on the children, is only paid once for the whole batch.
+//
+// The children are killed if they are still around after the configured child
+// timeout or when the passed in context is done, whichever comes first.
+func cloneNsN(ctx context.Context, s Set, flags, n int, cfg UnshareConfig) ([]Set, error) {
 	type result struct {
-		s   Set
+		sets []Set
+		err  error
+	}
+
+	type exit struct {
+		pid int
 		err error
 	}
 
@@ -83,121 +234,428 @@ func cloneNs(s Set, flags int, uidMaps, gidMaps []IDMap) (Set, error) {
 	go func() {
 		runtime.LockOSThread()
 
-		set, err := func() (retSet Set, retErr error) {
+		sets, err := func() (retSets []Set, retErr error) {
+			ctx, cancel := context.WithTimeout(ctx, cfg.childTimeout())
+			defer cancel()
+
+			var (
+				pid   int
+				stage = StagePipe
+			)
+			checkCtx := func() error {
+				if err := ctx.Err(); err != nil {
+					return &ChildTimeoutError{Pid: pid, Stage: stage, Err: err}
+				}
+				return nil
+			}
+
+			if err := checkCtx(); err != nil {
+				return nil, err
+			}
+
 			var pipe [2]int
 			if err := make_pipe(pipe[:]); err != nil {
-				return Set{}, fmt.Errorf("error creating pipe: %w", err)
+				return nil, fmt.Errorf("error creating pipe: %w", err)
 			}
 
 			defer func() {
 				if retErr != nil {
-					retSet.Close()
+					for _, s := range retSets {
+						s.Close()
+					}
+					retSets = nil
 				}
 			}()
 
-			pid, err := doClone(s, flags, pipe[0])
-			if err != nil {
+			running := make(map[int]bool, n)
+			chExit := make(chan exit, n)
+			defer func() {
 				sys_close(pipe[0])
 				sys_close(pipe[1])
-				return Set{}, err
-			}
 
-			chExit := make(chan error, 1)
-			go func() {
-				status, err := wait(int(pid))
-				if err != nil {
-					chExit <- fmt.Errorf("error waiting for child: %w", err)
-					return
+				if len(running) > 0 {
+					stage = StageWait
 				}
-				code := status.ExitStatus()
-				if code != 0 {
-					chExit <- fmt.Errorf("child exited with code %d: %w", code, unix.Errno(code))
-					return
-				}
-				chExit <- nil
-			}()
 
-			defer func() {
-				sys_close(pipe[0])
-				sys_close(pipe[1])
+				var killed bool
+				for len(running) > 0 {
+					var e exit
+					select {
+					case e = <-chExit:
+					case <-ctx.Done():
+						if killed {
+							e = <-chExit
+							break
+						}
+						killed = true
 
-				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-				defer cancel()
-				select {
-				case <-ctx.Done():
-					kill(int(pid))
-					err2 := <-chExit
-					if retErr == nil {
-						retErr = err2
-					} else {
-						retErr = fmt.Errorf("%w: %v", retErr, err2)
+						timeoutErr := &ChildTimeoutError{Stage: stage, Err: ctx.Err()}
+						for pid := range running {
+							if timeoutErr.Pid == 0 || pid < timeoutErr.Pid {
+								timeoutErr.Pid = pid
+							}
+							kill(pid)
+						}
+						if retErr == nil {
+							retErr = timeoutErr
+						} else if !errors.As(retErr, new(*ChildTimeoutError)) {
+							retErr = fmt.Errorf("%w: %v", retErr, timeoutErr)
+						}
+						continue
+					}
+					delete(running, e.pid)
+					if e.err == nil || killed {
+						continue
 					}
-				case err2 := <-chExit:
 					if retErr == nil {
-						retErr = err2
+						retErr = e.err
 					} else {
-						retErr = fmt.Errorf("%w: %v", retErr, err2)
+						retErr = fmt.Errorf("%w: %v", retErr, e.err)
 					}
 				}
 			}()
 
-			if err := setIDMaps(int(pid), uidMaps, gidMaps); err != nil {
-				return Set{}, fmt.Errorf("error setting id maps: %w", err)
+			// The children report on this pipe once their namespaces are ready.
+			var status [2]int
+			if err := make_pipe(status[:]); err != nil {
+				return nil, fmt.Errorf("error creating pipe: %w", err)
 			}
+			defer sys_close(status[0])
+			defer sys_close(status[1])
+			setup := &childSetup{propagation: cfg.MountPropagation, statusFd: status[1]}
+
+			children := n
+			if perChild := setsPerChild(s, flags, n, cfg); perChild > 1 {
+				setup.count = perChild
+				children = n / perChild
+			}
+
+			if err := s.set(true); err != nil {
+				return nil, err
+			}
+
+			pids := make([]int, 0, children)
+			for i := 0; i < children; i++ {
+				stage = StageClone
+				if err := checkCtx(); err != nil {
+					return nil, err
+				}
+
+				pid, err := clone(s, flags, pipe[0], setup)
+				if err != nil {
+					return nil, err
+				}
+				pids = append(pids, pid)
+				running[pid] = true
+
+				go func() {
+					status, err := wait(pid)
+					if err != nil {
+						chExit <- exit{pid: pid, err: fmt.Errorf("error waiting for child: %w", err)}
+						return
+					}
+					code := status.ExitStatus()
+					if code != 0 {
+						chExit <- exit{pid: pid, err: fmt.Errorf("child exited with code %d: %w", code, unix.Errno(code))}
+						return
+					}
+					chExit <- exit{pid: pid}
+				}()
+			}
+
+			readStatus := func() error {
+				stage = StageChildSetup
+				if err := readChildStatus(ctx, status[0]); err != nil {
+					if err := checkCtx(); err != nil {
+						return err
+					}
+					return fmt.Errorf("error in child setup: %w", err)
+				}
+				return nil
+			}
+
+			// Each child reports on the same pipe so all of them need to be
+			// done before the namespaces of any of them can be opened.
+			for range pids {
+				if err := readStatus(); err != nil {
+					return nil, err
+				}
+			}
+
+			sets := make([]Set, 0, n)
+			for i := 0; i < n; i++ {
+				pid = pids[i%len(pids)]
+				if i >= len(pids) {
+					// This is a child creating several sets, tell it to
+					// create the next one now the last one has been opened.
+					if _, err := unix.Write(pipe[1], []byte{0}); err != nil {
+						return sets, fmt.Errorf("error writing to child: %w", err)
+					}
+					if err := readStatus(); err != nil {
+						return sets, err
+					}
+				}
 
-			return FromDir(fmt.Sprintf("/proc/%d/ns", pid), flags)
+				stage = StageIDMaps
+				if err := checkCtx(); err != nil {
+					return sets, err
+				}
+				if err := setIDMaps(ctx, pid, cfg); err != nil {
+					if err := checkCtx(); err != nil {
+						return sets, err
+					}
+					return sets, fmt.Errorf("error setting id maps: %w", err)
+				}
+
+				stage = StageNamespaces
+				if err := checkCtx(); err != nil {
+					return sets, err
+				}
+				set, err := childNamespaces(s, pid, flags)
+				if err != nil {
+					return sets, err
+				}
+				sets = append(sets, set)
+			}
+			return sets, nil
 		}()
 
-		ch <- result{s: set, err: err}
+		ch <- result{sets: sets, err: err}
 	}()
 
 	r := <-ch
-	return r.s, r.err
+	return r.sets, r.err
 }
 
-func setIDMaps(pid int, uidMaps, gidMaps []IDMap) error {
-	buf := bytes.NewBuffer(nil)
-	if len(uidMaps) > 0 {
-		f, err := os.OpenFile(fmt.Sprintf("/proc/%d/uid_map", pid), os.O_WRONLY, 0)
+// setsPerChild returns how many sets a single child can create for cloneNsN.
+//
+// A child which joins the set's user namespace can unshare the namespaces in
+// `flags` repeatedly, so one child creates all the sets. A new user namespace
+// can only be created once per process: the child has no privileges in its
+// parent user namespace afterwards so it can never get back there to create a
+// sibling. A pid namespace can also only be unshared once per process. With
+// either of those each set gets its own child.
+//
+// Each mount namespace after the first is copied from the previous one, which
+// is only the same as copying from the set when mounts are not shared between
+// them.
+func setsPerChild(s Set, flags, n int, cfg UnshareConfig) int {
+	if flags&(unix.CLONE_NEWUSER|unix.CLONE_NEWPID) != 0 || s.flags&unix.CLONE_NEWUSER == 0 {
+		return 1
+	}
+	if flags&unix.CLONE_NEWNS != 0 && cfg.MountPropagation&^unix.MS_REC == unix.MS_SHARED {
+		return 1
+	}
+	return n
+}
+
+// childNamespaces opens the namespaces in `flags` of a child created by `clone`.
+//
+// A child which joined the set's user namespace unshared the namespaces
+// itself, and unsharing a pid or time namespace only applies to the children
+// of the caller, so those are opened from pid_for_children and
+// time_for_children instead. The child keeps an init process around in a new
+// pid namespace so that it can be opened.
+func childNamespaces(s Set, pid, flags int) (_ Set, retErr error) {
+	dir := fmt.Sprintf("/proc/%d/ns", pid)
+	if flags&unix.CLONE_NEWUSER != 0 || s.flags&unix.CLONE_NEWUSER == 0 {
+		return FromDir(dir, flags)
+	}
+
+	forChildren := flags & (unix.CLONE_NEWPID | unix.CLONE_NEWTIME)
+	set, err := FromDir(dir, flags&^forChildren)
+	if err != nil {
+		return Set{}, err
+	}
+	defer func() {
+		if retErr != nil {
+			set.Close()
+		}
+	}()
+
+	for kind, name := range nsFlagsReverse {
+		if forChildren&kind == 0 {
+			continue
+		}
+		fd, err := open(filepath.Join(dir, name+"_for_children"))
 		if err != nil {
-			return err
+			return Set{}, fmt.Errorf("error opening %s_for_children: %w", name, err)
 		}
-		defer f.Close()
-		for _, m := range uidMaps {
-			_, err := buf.Write([]byte(idMap(m) + "\n"))
-			if err != nil {
-				return err
+		set.fds[kind] = fd
+		set.flags |= kind
+	}
+	return set, nil
+}
+
+// readChildStatus reads a single status byte written by a child created with a `childSetup`.
+func readChildStatus(ctx context.Context, fd int) error {
+	fds := []unix.PollFd{{Fd: int32(fd), Events: unix.POLLIN}}
+	for {
+		timeout := -1
+		if deadline, ok := ctx.Deadline(); ok {
+			timeout = int(time.Until(deadline).Milliseconds())
+			if timeout <= 0 {
+				return ctx.Err()
 			}
 		}
 
-		if _, err := io.Copy(f, buf); err != nil {
+		n, err := unix.Poll(fds, timeout)
+		if err == unix.EINTR {
+			continue
+		}
+		if err != nil {
 			return err
 		}
+		if n == 0 {
+			continue
+		}
+		break
 	}
 
-	if len(gidMaps) > 0 {
-		buf.Reset()
-		f, err := os.OpenFile(fmt.Sprintf("/proc/%d/gid_map", pid), os.O_WRONLY, 0)
+	buf := make([]byte, 1)
+	for {
+		_, err := unix.Read(fd, buf)
+		if err == unix.EINTR {
+			continue
+		}
 		if err != nil {
 			return err
 		}
-		defer f.Close()
+		break
+	}
+	if buf[0] != 0 {
+		return unix.Errno(buf[0])
+	}
+	return nil
+}
 
-		for _, m := range uidMaps {
-			_, err := buf.Write([]byte(idMap(m) + "\n"))
-			if err != nil {
+func setIDMaps(ctx context.Context, pid int, cfg UnshareConfig) error {
+	// setgroups must be written before gid_map, the kernel rejects it afterwards.
+	if v := cfg.setGroups(); v != "" {
+		if err := os.WriteFile(fmt.Sprintf("/proc/%d/setgroups", pid), []byte(v), 0); err != nil {
+			return fmt.Errorf("error writing setgroups: %w", err)
+		}
+	}
+
+	if cfg.IDMapHelpers != nil {
+		if len(cfg.UidMaps) > 0 {
+			if err := runIDMapHelper(ctx, cfg.IDMapHelpers.NewUIDMap, pid, cfg.UidMaps); err != nil {
+				return err
+			}
+		}
+		if len(cfg.GidMaps) > 0 {
+			if err := runIDMapHelper(ctx, cfg.IDMapHelpers.NewGIDMap, pid, cfg.GidMaps); err != nil {
 				return err
 			}
 		}
+		return nil
+	}
 
-		if _, err := io.Copy(f, buf); err != nil {
+	if len(cfg.UidMaps) > 0 {
+		if err := ctx.Err(); err != nil {
 			return err
 		}
+		if err := writeIDMaps(fmt.Sprintf("/proc/%d/uid_map", pid), cfg.UidMaps); err != nil {
+			return fmt.Errorf("error writing uid_map: %w", err)
+		}
+	}
+
+	if len(cfg.GidMaps) > 0 {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := writeIDMaps(fmt.Sprintf("/proc/%d/gid_map", pid), cfg.GidMaps); err != nil {
+			return fmt.Errorf("error writing gid_map: %w", err)
+		}
 	}
 
 	return nil
 }
 
+// writeIDMaps writes the mappings to a uid_map or gid_map file.
+// The kernel requires all the mappings to be written with a single write.
+func writeIDMaps(p string, maps []IDMap) error {
+	f, err := os.OpenFile(p, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Write([]byte(FormatIDMaps(maps)))
+	return err
+}
+
 func idMap(mapping syscall.SysProcIDMap) string {
 	return fmt.Sprintf("%d %d %d", mapping.ContainerID, mapping.HostID, mapping.Size)
 }
+
+// withUserNSProcess creates a short lived process in the set's user namespace
+// and calls `f` with its pid.
+// This is used to get at information about a user namespace that is only
+// exposed through procfs, such as /proc/<pid>/uid_map.
+//
+// The process blocks on a pipe until `f` returns, it exits once the pipe is
+// closed and is then reaped.
+func withUserNSProcess(s Set, f func(pid int) error) error {
+	fd, ok := s.fds[unix.CLONE_NEWUSER]
+	if !ok {
+		return fmt.Errorf("flag not in set for %s", nsFlagsReverse[unix.CLONE_NEWUSER])
+	}
+	userns := Set{fds: map[nsFlag]int{unix.CLONE_NEWUSER: fd}, flags: unix.CLONE_NEWUSER}
+
+	ch := make(chan error, 1)
+	go func() {
+		// The thread is not modified by set when the set only contains a
+		// user namespace but the fork still needs to happen from a locked thread.
+		runtime.LockOSThread()
+		defer runtime.UnlockOSThread()
+
+		ch <- func() error {
+			var pipe [2]int
+			if err := make_pipe(pipe[:]); err != nil {
+				return fmt.Errorf("error creating pipe: %w", err)
+			}
+			defer sys_close(pipe[0])
+			// The child exits when the write end is closed.
+			closed := false
+			release := func() {
+				if !closed {
+					sys_close(pipe[1])
+					closed = true
+				}
+			}
+			defer release()
+
+			// The child reports on this pipe once it has joined the user namespace.
+			var status [2]int
+			if err := make_pipe(status[:]); err != nil {
+				return fmt.Errorf("error creating pipe: %w", err)
+			}
+			defer sys_close(status[0])
+			defer sys_close(status[1])
+
+			if err := userns.set(true); err != nil {
+				return err
+			}
+			pid, err := clone(userns, 0, pipe[0], &childSetup{statusFd: status[1]})
+			if err != nil {
+				return err
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), DefaultChildTimeout)
+			defer cancel()
+			if err := readChildStatus(ctx, status[0]); err != nil {
+				kill(pid)
+				wait(pid)
+				return fmt.Errorf("error waiting for child to join user namespace: %w", err)
+			}
+
+			defer func() {
+				release()
+				wait(pid)
+			}()
+			return f(pid)
+		}()
+	}()
+	return <-ch
+}
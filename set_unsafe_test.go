@@ -81,7 +81,9 @@ func (s Set) testDoRexec(t *testing.T, cmd string, argv []string, envv []string)
 			fd   int
 			kind int
 		}
-		var fds []namespace
+		// Make sure the slice is fully allocated before forking, the child
+		// cannot allocate (or grow its stack).
+		fds := make([]namespace, 0, len(s.fds))
 		for kind, fd := range s.fds {
 			fds = append(fds, namespace{fd: fd, kind: kind})
 		}
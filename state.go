@@ -0,0 +1,120 @@
+package gonso
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/unix"
+)
+
+// updateState loads the json encoded state stored at `p`, passes it to `f`
+// and, if `f` does not return an error, writes the modified state back.
+//
+// An exclusive lock is held on `p`.lock for the duration of the call so this
+// is safe to use from multiple goroutines and processes.
+// The state is replaced atomically so a crash will never leave a partially
+// written file behind.
+func updateState[T any](p string, f func(*T) error) error {
+	if err := os.MkdirAll(filepath.Dir(p), 0o700); err != nil {
+		return err
+	}
+
+	lock, err := os.OpenFile(p+".lock", os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return fmt.Errorf("error opening lock file: %w", err)
+	}
+	defer lock.Close()
+
+	if err := flock(int(lock.Fd()), unix.LOCK_EX); err != nil {
+		return fmt.Errorf("error locking state: %w", err)
+	}
+	// Closing the file releases the lock.
+
+	var state T
+	data, err := os.ReadFile(p)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("error reading state: %w", err)
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &state); err != nil {
+			return fmt.Errorf("error decoding state from %s: %w", p, err)
+		}
+	}
+
+	if err := f(&state); err != nil {
+		return err
+	}
+
+	data, err = json.Marshal(&state)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(p), "."+filepath.Base(p))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error writing state: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error writing state: %w", err)
+	}
+	return os.Rename(tmp.Name(), p)
+}
+
+// stateRecord is an allocation stored in a state file with `updateState`.
+type stateRecord interface {
+	recordID() string
+}
+
+// allocRecord is embedded in the allocation records of a state file.
+type allocRecord struct {
+	ID string `json:"id"`
+}
+
+func (r allocRecord) recordID() string {
+	return r.ID
+}
+
+// allocState is the content of a state file holding allocations.
+type allocState[T stateRecord] struct {
+	Allocations []T `json:"allocations"`
+}
+
+// prune drops the allocations which `live` reports are no longer in use, so
+// allocations which were never released, e.g. because the process which made
+// them crashed, do not leak.
+func (s *allocState[T]) prune(live func(T) bool) {
+	kept := s.Allocations[:0]
+	for _, r := range s.Allocations {
+		if live(r) {
+			kept = append(kept, r)
+		}
+	}
+	s.Allocations = kept
+}
+
+// remove drops the allocation with the given id, if there is one.
+func (s *allocState[T]) remove(id string) {
+	for i, r := range s.Allocations {
+		if r.recordID() == id {
+			s.Allocations = append(s.Allocations[:i], s.Allocations[i+1:]...)
+			return
+		}
+	}
+}
+
+// releaseRecord removes the allocation with the given id from the state file at `p`.
+func releaseRecord[T stateRecord](p, id string) error {
+	return updateState(p, func(state *allocState[T]) error {
+		state.remove(id)
+		return nil
+	})
+}
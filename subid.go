@@ -0,0 +1,207 @@
+package gonso
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+var (
+	// These are variables so they can be changed in tests.
+	subUIDPath = "/etc/subuid"
+	subGIDPath = "/etc/subgid"
+)
+
+// SubIDRange is a range of subordinate ids as found in /etc/subuid or /etc/subgid.
+type SubIDRange struct {
+	// Start is the first id in the range.
+	Start int
+	// Count is the number of ids in the range.
+	Count int
+}
+
+// ErrNoSubIDs is returned when there are no subordinate ids for a user.
+var ErrNoSubIDs = errors.New("no subordinate ids found")
+
+// LookupSubUIDs returns the subordinate uid ranges for the given user from /etc/subuid.
+// The user can be specified either by name or by uid.
+func LookupSubUIDs(name string) ([]SubIDRange, error) {
+	return lookupSubIDs(subUIDPath, name)
+}
+
+// LookupSubGIDs returns the subordinate gid ranges for the given user from /etc/subgid.
+// The user can be specified either by name or by uid.
+func LookupSubGIDs(name string) ([]SubIDRange, error) {
+	return lookupSubIDs(subGIDPath, name)
+}
+
+func lookupSubIDs(p, name string) ([]SubIDRange, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	// Entries can reference the user by name or by uid, so match on both.
+	names := []string{name}
+	if _, err := strconv.Atoi(name); err == nil {
+		if u, err := user.LookupId(name); err == nil {
+			names = append(names, u.Username)
+		}
+	} else if u, err := user.Lookup(name); err == nil {
+		names = append(names, u.Uid)
+	}
+
+	ranges, err := parseSubIDs(f, names...)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %s: %w", p, err)
+	}
+	if len(ranges) == 0 {
+		return nil, fmt.Errorf("%w for %s in %s", ErrNoSubIDs, name, p)
+	}
+	return ranges, nil
+}
+
+// parseSubIDs parses the subid file format (`name:start:count`) and returns
+// the ranges for any entry matching one of `names`.
+func parseSubIDs(r io.Reader, names ...string) ([]SubIDRange, error) {
+	var ranges []SubIDRange
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Split(line, ":")
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("invalid entry: %q", line)
+		}
+
+		var match bool
+		for _, name := range names {
+			if fields[0] == name {
+				match = true
+				break
+			}
+		}
+		if !match {
+			continue
+		}
+
+		start, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid start in entry %q: %w", line, err)
+		}
+		count, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("invalid count in entry %q: %w", line, err)
+		}
+		if start < 0 || count <= 0 {
+			return nil, fmt.Errorf("invalid range in entry %q", line)
+		}
+		ranges = append(ranges, SubIDRange{Start: start, Count: count})
+	}
+
+	return ranges, scanner.Err()
+}
+
+// subIDMaps builds the mappings for a user namespace where `id` is mapped to
+// root and the subordinate ranges are mapped, in order, starting at 1.
+func subIDMaps(id int, ranges []SubIDRange) []IDMap {
+	maps := []IDMap{{ContainerID: 0, HostID: id, Size: 1}}
+
+	next := 1
+	for _, r := range ranges {
+		maps = append(maps, IDMap{ContainerID: next, HostID: r.Start, Size: r.Count})
+		next += r.Count
+	}
+	return maps
+}
+
+// IDMapHelpers are the setuid helpers (as provided by shadow-utils) used to write
+// id mappings for a user namespace on behalf of an unprivileged user.
+type IDMapHelpers struct {
+	// NewUIDMap is the path to the newuidmap binary.
+	NewUIDMap string
+	// NewGIDMap is the path to the newgidmap binary.
+	NewGIDMap string
+}
+
+// WithIDMapHelpers sets the helpers used to write id mappings for a new user
+// namespace instead of writing to /proc/<pid>/uid_map and /proc/<pid>/gid_map directly.
+// It can be used as an UnshareOpt to configure the Unshare function.
+//
+// If either path is empty, the binary is looked up in $PATH.
+func WithIDMapHelpers(newuidmap, newgidmap string) UnshareOpt {
+	return func(c *UnshareConfig) {
+		if newuidmap == "" {
+			newuidmap = "newuidmap"
+		}
+		if newgidmap == "" {
+			newgidmap = "newgidmap"
+		}
+		c.IDMapHelpers = &IDMapHelpers{NewUIDMap: newuidmap, NewGIDMap: newgidmap}
+	}
+}
+
+// WithRootlessIDMaps configures id mappings for the current user from
+// /etc/subuid and /etc/subgid.
+// It can be used as an UnshareOpt to configure the Unshare function.
+//
+// The current effective uid and gid are mapped to 0 in the new user namespace
+// and the subordinate ids are mapped after that.
+// Since unprivileged users are not allowed to write these mappings directly,
+// newuidmap and newgidmap are used to write them unless other helpers have
+// been configured with `WithIDMapHelpers`.
+func WithRootlessIDMaps() UnshareOpt {
+	return func(c *UnshareConfig) {
+		uid := os.Geteuid()
+		uids, err := LookupSubUIDs(strconv.Itoa(uid))
+		if err != nil {
+			c.err = err
+			return
+		}
+		gids, err := LookupSubGIDs(strconv.Itoa(uid))
+		if err != nil {
+			c.err = err
+			return
+		}
+
+		c.UidMaps = subIDMaps(uid, uids)
+		c.GidMaps = subIDMaps(os.Getegid(), gids)
+		if c.IDMapHelpers == nil {
+			WithIDMapHelpers("", "")(c)
+		}
+	}
+}
+
+// runIDMapHelper runs the id map helper (newuidmap or newgidmap) for the given pid.
+//
+// The helper is run from a new goroutine so that it is not forked from a
+// thread that has been moved into other namespaces (in particular a different
+// mount namespace where the helper may not exist).
+func runIDMapHelper(ctx context.Context, helper string, pid int, maps []IDMap) error {
+	args := []string{strconv.Itoa(pid)}
+	for _, m := range maps {
+		args = append(args, strconv.Itoa(m.ContainerID), strconv.Itoa(m.HostID), strconv.Itoa(m.Size))
+	}
+
+	ch := make(chan error, 1)
+	go func() {
+		out, err := exec.CommandContext(ctx, helper, args...).CombinedOutput()
+		if err != nil {
+			err = fmt.Errorf("error running %s: %w: %s", helper, err, strings.TrimSpace(string(out)))
+		}
+		ch <- err
+	}()
+	return <-ch
+}
@@ -0,0 +1,178 @@
+package gonso
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const (
+	cmdNewUIDMap = "newuidmap"
+	cmdNewGIDMap = "newgidmap"
+
+	// fakeIDMapLogEnv is the env var used by the fake id map helpers to record
+	// how they were called.
+	fakeIDMapLogEnv = "GONSO_FAKE_IDMAP_LOG"
+)
+
+func fakeNewUIDMap() {
+	fakeIDMapHelper("uid_map")
+}
+
+func fakeNewGIDMap() {
+	fakeIDMapHelper("gid_map")
+}
+
+// fakeIDMapHelper is a stand-in for newuidmap/newgidmap.
+// It writes the mappings passed on the command line (`<pid> <id> <lowerid> <count>...`)
+// directly to the given file in /proc/<pid>.
+func fakeIDMapHelper(file string) {
+	err := func() error {
+		args := os.Args[1:]
+		if len(args) < 4 || (len(args)-1)%3 != 0 {
+			return fmt.Errorf("usage: %s <pid> <id> <lowerid> <count> [ <id> <lowerid> <count> ] ...", os.Args[0])
+		}
+
+		var buf strings.Builder
+		for i := 1; i < len(args); i += 3 {
+			fmt.Fprintf(&buf, "%s %s %s\n", args[i], args[i+1], args[i+2])
+		}
+		if err := os.WriteFile(filepath.Join("/proc", args[0], file), []byte(buf.String()), 0); err != nil {
+			return err
+		}
+
+		if p := os.Getenv(fakeIDMapLogEnv); p != "" {
+			f, err := os.OpenFile(p, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			fmt.Fprintln(f, filepath.Base(os.Args[0]), strings.Join(args[1:], " "))
+		}
+		return nil
+	}()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v", err)
+		os.Exit(1)
+	}
+}
+
+// fakeIDMapHelpers sets up the test binary to act as newuidmap and newgidmap.
+func fakeIDMapHelpers(t *testing.T) (newuidmap, newgidmap string) {
+	t.Helper()
+
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	newuidmap = filepath.Join(dir, cmdNewUIDMap)
+	newgidmap = filepath.Join(dir, cmdNewGIDMap)
+	if err := os.Symlink(exe, newuidmap); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(exe, newgidmap); err != nil {
+		t.Fatal(err)
+	}
+	return newuidmap, newgidmap
+}
+
+func TestParseSubIDs(t *testing.T) {
+	const data = `
+# comment
+root:100000:65536
+1000:200000:65536
+someone:300000:10
+root:400000:1000
+`
+
+	ranges, err := parseSubIDs(strings.NewReader(data), "root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []SubIDRange{{Start: 100000, Count: 65536}, {Start: 400000, Count: 1000}}
+	if fmt.Sprint(ranges) != fmt.Sprint(expected) {
+		t.Errorf("expected %v, got %v", expected, ranges)
+	}
+
+	ranges, err = parseSubIDs(strings.NewReader(data), "nobody", "1000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = []SubIDRange{{Start: 200000, Count: 65536}}
+	if fmt.Sprint(ranges) != fmt.Sprint(expected) {
+		t.Errorf("expected %v, got %v", expected, ranges)
+	}
+
+	if _, err := parseSubIDs(strings.NewReader("root:1:2:3")); err == nil {
+		t.Error("expected error for invalid entry")
+	}
+	if _, err := parseSubIDs(strings.NewReader("root:1:0"), "root"); err == nil {
+		t.Error("expected error for empty range")
+	}
+}
+
+func TestRootlessIDMaps(t *testing.T) {
+	dir := t.TempDir()
+
+	origUID, origGID := subUIDPath, subGIDPath
+	defer func() {
+		subUIDPath, subGIDPath = origUID, origGID
+	}()
+
+	subUIDPath = filepath.Join(dir, "subuid")
+	subGIDPath = filepath.Join(dir, "subgid")
+
+	if _, err := Unshare(NS_USER, WithRootlessIDMaps()); err == nil {
+		t.Fatal("expected error without subid files")
+	}
+
+	if err := os.WriteFile(subUIDPath, []byte(fmt.Sprintf("%d:100000:65536\n", os.Geteuid())), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(subGIDPath, []byte("nobody:100000:65536\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Unshare(NS_USER, WithRootlessIDMaps()); !errors.Is(err, ErrNoSubIDs) {
+		t.Fatalf("expected no subids error, got: %v", err)
+	}
+
+	if err := os.WriteFile(subGIDPath, []byte(fmt.Sprintf("%d:200000:1000\n%d:300000:10\n", os.Geteuid(), os.Geteuid())), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	logPath := filepath.Join(dir, "log")
+	t.Setenv(fakeIDMapLogEnv, logPath)
+
+	newuidmap, newgidmap := fakeIDMapHelpers(t)
+	set, err := Unshare(NS_USER|NS_NET, WithIDMapHelpers(newuidmap, newgidmap), WithRootlessIDMaps())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer set.Close()
+
+	uidMaps := []IDMap{
+		{ContainerID: 0, HostID: os.Geteuid(), Size: 1},
+		{ContainerID: 1, HostID: 100000, Size: 65536},
+	}
+	gidMaps := []IDMap{
+		{ContainerID: 0, HostID: os.Getegid(), Size: 1},
+		{ContainerID: 1, HostID: 200000, Size: 1000},
+		{ContainerID: 1001, HostID: 300000, Size: 10},
+	}
+	checkIDMaps(t, set, uidMaps, gidMaps)
+
+	data, err := os.ReadFile(logPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := fmt.Sprintf("newuidmap 0 %d 1 1 100000 65536\nnewgidmap 0 %d 1 1 200000 1000 1001 300000 10\n", os.Geteuid(), os.Getegid())
+	if string(data) != expected {
+		t.Errorf("unexpected helper calls:\n%s\nexpected:\n%s", data, expected)
+	}
+}
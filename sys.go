@@ -1,6 +1,8 @@
 package gonso
 
 import (
+	"unsafe"
+
 	"golang.org/x/sys/unix"
 )
 
@@ -108,11 +110,210 @@ func wait(pid int) (unix.WaitStatus, error) {
 	}
 }
 
+func mountFS(src, target, fstype string, flags uintptr, data string) error {
+	for {
+		err := unix.Mount(src, target, fstype, flags, data)
+		if err == nil {
+			return nil
+		}
+		if err != unix.EINTR {
+			return err
+		}
+	}
+}
+
+func pivotRoot(newRoot, putOld string) error {
+	for {
+		err := unix.PivotRoot(newRoot, putOld)
+		if err == nil {
+			return nil
+		}
+		if err != unix.EINTR {
+			return err
+		}
+	}
+}
+
+func statx(dirfd int, p string, flags, mask int, st *unix.Statx_t) error {
+	for {
+		err := unix.Statx(dirfd, p, flags, mask, st)
+		if err == nil {
+			return nil
+		}
+		if err != unix.EINTR {
+			return err
+		}
+	}
+}
+
+func openat2(dirfd int, p string, how *unix.OpenHow) (int, error) {
+	how.Flags |= unix.O_CLOEXEC
+	for {
+		fd, err := unix.Openat2(dirfd, p, how)
+		if err == nil {
+			return fd, nil
+		}
+		// EAGAIN is returned when a rename races with resolving a path
+		// restricted by the RESOLVE_* flags, it is safe to just try again.
+		if err != unix.EINTR && err != unix.EAGAIN {
+			return -1, err
+		}
+	}
+}
+
+func mountPropagation(p string, flags int) error {
+	for {
+		err := unix.Mount("", p, "", uintptr(flags), "")
+		if err == nil {
+			return nil
+		}
+		if err != unix.EINTR {
+			return err
+		}
+	}
+}
+
 func unmount(p string) {
+	umount(p, unix.MNT_DETACH)
+}
+
+func umount(p string, flags int) error {
 	for {
-		err := unix.Unmount(p, unix.MNT_DETACH)
-		if err == nil || err != unix.EINTR {
-			return
+		err := unix.Unmount(p, flags)
+		if err == nil {
+			return nil
+		}
+		if err != unix.EINTR {
+			return err
+		}
+	}
+}
+
+func chdir(p string) error {
+	for {
+		err := unix.Chdir(p)
+		if err == nil {
+			return nil
+		}
+		if err != unix.EINTR {
+			return err
+		}
+	}
+}
+
+// hasCap checks if the current thread has the given capability in its effective set.
+func hasCap(c int) bool {
+	hdr := unix.CapUserHeader{Version: unix.LINUX_CAPABILITY_VERSION_3}
+	var data [2]unix.CapUserData
+	if err := unix.Capget(&hdr, &data[0]); err != nil {
+		return false
+	}
+	return data[c/32].Effective&(1<<(uint(c)%32)) != 0
+}
+
+func flock(fd, how int) error {
+	for {
+		err := unix.Flock(fd, how)
+		if err == nil {
+			return nil
+		}
+		if err != unix.EINTR {
+			return err
+		}
+	}
+}
+
+func openTree(dirfd int, p string, flags uint) (int, error) {
+	for {
+		fd, err := unix.OpenTree(dirfd, p, flags|unix.O_CLOEXEC)
+		if err == nil {
+			return fd, nil
+		}
+		if err != unix.EINTR {
+			return -1, err
+		}
+	}
+}
+
+func mountSetattr(dirfd int, p string, flags uint, attr *unix.MountAttr) error {
+	for {
+		err := unix.MountSetattr(dirfd, p, flags, attr)
+		if err == nil {
+			return nil
+		}
+		if err != unix.EINTR {
+			return err
+		}
+	}
+}
+
+func moveMount(fromDirfd int, fromPath string, toDirfd int, toPath string, flags int) error {
+	for {
+		err := unix.MoveMount(fromDirfd, fromPath, toDirfd, toPath, flags)
+		if err == nil {
+			return nil
+		}
+		if err != unix.EINTR {
+			return err
+		}
+	}
+}
+
+func fsopen(fstype string, flags int) (int, error) {
+	for {
+		fd, err := unix.Fsopen(fstype, flags|unix.FSOPEN_CLOEXEC)
+		if err == nil {
+			return fd, nil
+		}
+		if err != unix.EINTR {
+			return -1, err
+		}
+	}
+}
+
+func fsmount(fd int, flags int, attrs int) (int, error) {
+	for {
+		mfd, err := unix.Fsmount(fd, flags|unix.FSMOUNT_CLOEXEC, attrs)
+		if err == nil {
+			return mfd, nil
+		}
+		if err != unix.EINTR {
+			return -1, err
+		}
+	}
+}
+
+// Commands for fsconfig(2), these are not exported by x/sys/unix.
+const (
+	fsconfigSetFlag   = 0
+	fsconfigSetString = 1
+	fsconfigCmdCreate = 6
+)
+
+func fsconfig(fd int, cmd uint, key, value string) error {
+	var keyp, valuep *byte
+	if key != "" {
+		p, err := unix.BytePtrFromString(key)
+		if err != nil {
+			return err
+		}
+		keyp = p
+	}
+	if cmd == fsconfigSetString {
+		p, err := unix.BytePtrFromString(value)
+		if err != nil {
+			return err
+		}
+		valuep = p
+	}
+
+	for {
+		_, _, errno := unix.Syscall6(unix.SYS_FSCONFIG, uintptr(fd), uintptr(cmd), uintptr(unsafe.Pointer(keyp)), uintptr(unsafe.Pointer(valuep)), 0, 0)
+		if errno == 0 {
+			return nil
+		}
+		if errno != unix.EINTR {
+			return errno
 		}
 	}
 }
@@ -2,12 +2,15 @@ package gonso
 
 import (
 	"bufio"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"golang.org/x/sys/unix"
 )
@@ -173,6 +176,41 @@ func readMappings() {
 	}
 }
 
+const cmdReadSetgroups = "readsetgroups"
+
+func readSetgroups() {
+	data, err := os.ReadFile("/proc/self/setgroups")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v", err)
+		os.Exit(1)
+	}
+	os.Stdout.Write(data)
+}
+
+func checkSetgroups(t *testing.T, set Set, expected string) {
+	t.Helper()
+
+	ch, cancel, stdio, err := set.testDoRexec(t, cmdReadSetgroups, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdio.Close()
+	defer cancel()
+
+	data, err := io.ReadAll(stdio.out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := <-ch; code != 0 {
+		data, _ := io.ReadAll(stdio.err)
+		t.Fatalf("unexpected exit code %d: %s", code, string(data))
+	}
+
+	if v := strings.TrimSpace(string(data)); v != expected {
+		t.Errorf("expected setgroups to be %q, got %q", expected, v)
+	}
+}
+
 func checkIDMaps(t *testing.T, set Set, uidMaps, gidMaps []IDMap) {
 	ch, cancel, stdio, err := set.testDoRexec(t, cmdReadMappings, nil, nil)
 	if err != nil {
@@ -244,7 +282,8 @@ func TestUserns(t *testing.T) {
 		t.Fatal("exepcted error callindg `Do` with a userns")
 	}
 
-	unshared, err := set.Unshare(unix.CLONE_NEWIPC)
+	unshareFlags := unix.CLONE_NEWIPC | unix.CLONE_NEWNS | unix.CLONE_NEWUTS | unix.CLONE_NEWPID | unix.CLONE_NEWNET
+	unshared, err := set.Unshare(unshareFlags)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -252,6 +291,23 @@ func TestUserns(t *testing.T) {
 	if set.testGetID(t, unix.CLONE_NEWUSER) != unshared.testGetID(t, unix.CLONE_NEWUSER) {
 		t.Fatal("expected same user id")
 	}
+	cur, err := Current(unshareFlags)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cur.Close()
+	// The new namespaces must belong to the set's user namespace rather than ours.
+	for kind, name := range nsFlagsReverse {
+		if unshareFlags&kind == 0 {
+			continue
+		}
+		if unshared.testGetID(t, kind) == cur.testGetID(t, kind) {
+			t.Errorf("expected a new %s namespace", name)
+		}
+		if owner := unshared.testGetOwnerID(t, kind); owner != set.testGetID(t, unix.CLONE_NEWUSER) {
+			t.Errorf("expected %s namespace to be owned by %s, got %s", name, set.testGetID(t, unix.CLONE_NEWUSER), owner)
+		}
+	}
 
 	unshared.Close()
 
@@ -286,6 +342,339 @@ func TestUserns(t *testing.T) {
 	}
 }
 
+func TestUnshareN(t *testing.T) {
+	checkUnique := func(t *testing.T, sets []Set, flags int) {
+		t.Helper()
+
+		for kind := range nsFlagsReverse {
+			if flags&kind == 0 {
+				continue
+			}
+			seen := make(map[string]bool, len(sets))
+			for _, s := range sets {
+				id := s.testGetID(t, kind)
+				if seen[id] {
+					t.Errorf("duplicate %s namespace: %s", nsFlagsReverse[kind], id)
+				}
+				seen[id] = true
+			}
+		}
+	}
+
+	t.Run("no userns", func(t *testing.T) {
+		flags := NS_NET | NS_IPC | NS_UTS
+		sets, err := UnshareN(flags, 4)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, s := range sets {
+			defer s.Close()
+		}
+
+		if len(sets) != 4 {
+			t.Fatalf("expected 4 sets, got %d", len(sets))
+		}
+		checkUnique(t, sets, flags)
+
+		for _, s := range sets {
+			if err := s.Do(func() {}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+
+	t.Run("userns", func(t *testing.T) {
+		flags := NS_USER | NS_NET
+		maps := []IDMap{{HostID: 0, ContainerID: 0, Size: 1}}
+		sets, err := UnshareN(flags, 4, WithIDMaps(maps, maps))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, s := range sets {
+			defer s.Close()
+		}
+
+		if len(sets) != 4 {
+			t.Fatalf("expected 4 sets, got %d", len(sets))
+		}
+		checkUnique(t, sets, flags)
+	})
+
+	t.Run("joined userns", func(t *testing.T) {
+		maps := []IDMap{{HostID: 0, ContainerID: 0, Size: 1}}
+		userns, err := Unshare(NS_USER, WithIDMaps(maps, maps))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer userns.Close()
+
+		for name, flags := range map[string]int{
+			"single helper": NS_NET | NS_IPC | NS_MNT,
+			"pid":           NS_NET | NS_PID,
+		} {
+			flags := flags
+			t.Run(name, func(t *testing.T) {
+				var opts []UnshareOpt
+				if flags&NS_MNT != 0 {
+					opts = append(opts, WithMountPropagation(unix.MS_PRIVATE, true))
+				}
+				sets, err := userns.UnshareN(flags, 4, opts...)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, s := range sets {
+					defer s.Close()
+				}
+
+				if len(sets) != 4 {
+					t.Fatalf("expected 4 sets, got %d", len(sets))
+				}
+				checkUnique(t, sets, flags)
+
+				owner := userns.testGetID(t, NS_USER)
+				for _, s := range sets {
+					if s.testGetID(t, NS_USER) != owner {
+						t.Error("expected the user namespace to be preserved")
+					}
+					for kind, name := range nsFlagsReverse {
+						if flags&kind != 0 && s.testGetOwnerID(t, kind) != owner {
+							t.Errorf("expected %s namespace to be owned by %s", name, owner)
+						}
+					}
+				}
+			})
+		}
+	})
+
+	t.Run("zero", func(t *testing.T) {
+		sets, err := UnshareN(NS_NET, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(sets) != 0 {
+			t.Fatalf("expected no sets, got %d", len(sets))
+		}
+	})
+}
+
+func TestUnshareContext(t *testing.T) {
+	t.Run("cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := UnshareContext(ctx, NS_NET)
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected context cancelled error, got: %v", err)
+		}
+
+		_, err = UnshareContext(ctx, NS_USER|NS_NET)
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected context cancelled error, got: %v", err)
+		}
+
+		var timeoutErr *ChildTimeoutError
+		if !errors.As(err, &timeoutErr) {
+			t.Fatalf("expected child timeout error, got: %T", err)
+		}
+		if timeoutErr.Stage != StagePipe {
+			t.Errorf("unexpected stage: %s", timeoutErr.Stage)
+		}
+		if msg := err.Error(); strings.Contains(msg, "timeout") {
+			t.Errorf("cancelled error should not be reported as a timeout: %s", msg)
+		}
+
+		sets, err := UnshareNContext(ctx, NS_NET, 2)
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected context cancelled error, got: %v", err)
+		}
+		if len(sets) != 0 {
+			t.Errorf("expected no sets, got %d", len(sets))
+		}
+	})
+
+	t.Run("after child", func(t *testing.T) {
+		// The child only sees the context through a derived context which is
+		// never cancelled, so only the check after the child is done fails.
+		ctx := cancelledLateCtx{context.Background()}
+
+		_, err := UnshareContext(ctx, NS_USER|NS_NET)
+		var timeoutErr *ChildTimeoutError
+		if !errors.As(err, &timeoutErr) {
+			t.Fatalf("expected child timeout error, got: %v", err)
+		}
+		if timeoutErr.Stage != StageSetup {
+			t.Errorf("unexpected stage: %s", timeoutErr.Stage)
+		}
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("expected context cancelled error, got: %v", err)
+		}
+
+		_, err = UnshareNContext(ctx, NS_USER|NS_NET, 2)
+		if !errors.As(err, &timeoutErr) || timeoutErr.Stage != StageSetup {
+			t.Errorf("expected child timeout error during %s, got: %v", StageSetup, err)
+		}
+	})
+
+	t.Run("child timeout", func(t *testing.T) {
+		_, err := Unshare(NS_USER|NS_NET, WithChildTimeout(time.Nanosecond))
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("expected deadline exceeded error, got: %v", err)
+		}
+
+		var timeoutErr *ChildTimeoutError
+		if !errors.As(err, &timeoutErr) {
+			t.Fatalf("expected child timeout error, got: %T", err)
+		}
+	})
+
+	t.Run("deadline", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		maps := []IDMap{{HostID: 0, ContainerID: 0, Size: 1}}
+		s, err := UnshareContext(ctx, NS_USER|NS_NET, WithIDMaps(maps, maps))
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.Close()
+	})
+}
+
+// cancelledLateCtx reports that it is cancelled without ever closing its Done
+// channel, so contexts derived from it are not cancelled.
+type cancelledLateCtx struct {
+	context.Context
+}
+
+func (cancelledLateCtx) Err() error {
+	return context.Canceled
+}
+
+func TestUsernsDistinctMaps(t *testing.T) {
+	flags := NS_USER | NS_NET
+
+	uidMaps := []IDMap{
+		{ContainerID: 0, HostID: 1000, Size: 1},
+		{ContainerID: 1, HostID: 100000, Size: 65536},
+	}
+	gidMaps := []IDMap{
+		{ContainerID: 0, HostID: 2000, Size: 1},
+		{ContainerID: 1, HostID: 200000, Size: 1000},
+		{ContainerID: 5000, HostID: 300000, Size: 10},
+	}
+
+	t.Run("default", func(t *testing.T) {
+		set, err := Unshare(flags, WithIDMaps(uidMaps, gidMaps))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer set.Close()
+
+		checkIDMaps(t, set, uidMaps, gidMaps)
+		// We are privileged so the kernel default is left alone.
+		checkSetgroups(t, set, "allow")
+	})
+
+	t.Run("deny", func(t *testing.T) {
+		set, err := Unshare(flags, WithIDMaps(uidMaps, gidMaps), WithSetGroups(false))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer set.Close()
+
+		checkIDMaps(t, set, uidMaps, gidMaps)
+		checkSetgroups(t, set, "deny")
+	})
+
+	t.Run("allow", func(t *testing.T) {
+		set, err := Unshare(flags, WithIDMaps(uidMaps, gidMaps), WithSetGroups(true))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer set.Close()
+
+		checkIDMaps(t, set, uidMaps, gidMaps)
+		checkSetgroups(t, set, "allow")
+	})
+
+	t.Run("no userns", func(t *testing.T) {
+		_, err := Unshare(NS_NET, WithSetGroups(false))
+		if !errors.Is(err, unix.EINVAL) {
+			t.Fatalf("expected EINVAL, got: %v", err)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		overlap := []IDMap{
+			{ContainerID: 0, HostID: 1000, Size: 10},
+			{ContainerID: 5, HostID: 2000, Size: 10},
+		}
+		_, err := Unshare(flags, WithIDMaps(uidMaps, overlap))
+		if !errors.Is(err, unix.EINVAL) {
+			t.Fatalf("expected EINVAL, got: %v", err)
+		}
+	})
+}
+
+func TestMountPropagation(t *testing.T) {
+	// rootPropagation returns the optional fields of "/" in the mount namespace of the set.
+	rootPropagation := func(t *testing.T, set Set) []string {
+		t.Helper()
+
+		mounts, err := set.Mounts(MountpointFilter("/"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(mounts) == 0 {
+			t.Fatal("could not find / in mountinfo")
+		}
+		// If there are multiple mounts on "/" the last one is the visible one.
+		return mounts[len(mounts)-1].Optional
+	}
+
+	isShared := func(fields []string) bool {
+		for _, f := range fields {
+			if strings.HasPrefix(f, "shared:") {
+				return true
+			}
+		}
+		return false
+	}
+
+	for name, flags := range map[string]int{"mnt": NS_MNT, "userns": NS_MNT | NS_USER} {
+		flags := flags
+		t.Run(name, func(t *testing.T) {
+			set, err := Unshare(flags, WithMountPropagation(unix.MS_SHARED, true))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer set.Close()
+
+			if fields := rootPropagation(t, set); !isShared(fields) {
+				t.Errorf("expected / to be shared: %v", fields)
+			}
+
+			sets, err := UnshareN(flags, 2, WithMountPropagation(unix.MS_PRIVATE, true))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, set := range sets {
+				defer set.Close()
+				if fields := rootPropagation(t, set); len(fields) != 0 {
+					t.Errorf("expected / to be private: %v", fields)
+				}
+			}
+		})
+	}
+
+	if _, err := Unshare(NS_NET, WithMountPropagation(unix.MS_PRIVATE, false)); !errors.Is(err, unix.EINVAL) {
+		t.Errorf("expected EINVAL without a mount namespace, got: %v", err)
+	}
+	if _, err := Unshare(NS_MNT, WithMountPropagation(unix.MS_BIND, false)); !errors.Is(err, unix.EINVAL) {
+		t.Errorf("expected EINVAL for invalid propagation, got: %v", err)
+	}
+}
+
 func TestFromDir(t *testing.T) {
 	flags := unix.CLONE_NEWNET | unix.CLONE_NEWIPC
 
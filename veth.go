@@ -0,0 +1,99 @@
+package gonso
+
+import (
+	"fmt"
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// vethInfoPeer is VETH_INFO_PEER from linux/veth.h
+const vethInfoPeer = 1
+
+// VethOpts configures the veth pair created by `Veth`.
+type VethOpts struct {
+	// MTU, when non-zero, is set on both ends of the pair.
+	MTU int
+	// HardwareAddrA, when set, is the MAC address of the end in set `a`.
+	HardwareAddrA net.HardwareAddr
+	// HardwareAddrB, when set, is the MAC address of the end in set `b`.
+	HardwareAddrB net.HardwareAddr
+	// Up, when true, sets both ends of the pair up.
+	// Otherwise the ends are left down, e.g. so they can be configured first.
+	Up bool
+}
+
+// Veth creates a veth pair with one end named `nameA` in the network namespace
+// of `a` and the other end named `nameB` in the network namespace of `b`.
+// Both sets must contain a network namespace, they may be the same namespace.
+//
+// The pair is created directly in the target namespaces so the ends never show
+// up in the caller's namespace. If `opts.Up` is set both ends are set up
+// before `Veth` returns. If anything fails the pair is removed again.
+func Veth(a, b Set, nameA, nameB string, opts VethOpts) (retErr error) {
+	fdA, ok := a.fds[unix.CLONE_NEWNET]
+	if !ok {
+		return fmt.Errorf("flag not in set a for %s", nsFlagsReverse[unix.CLONE_NEWNET])
+	}
+	fdB, ok := b.fds[unix.CLONE_NEWNET]
+	if !ok {
+		return fmt.Errorf("flag not in set b for %s", nsFlagsReverse[unix.CLONE_NEWNET])
+	}
+
+	connA, err := a.netlink()
+	if err != nil {
+		return err
+	}
+	defer connA.Close()
+
+	connB, err := b.netlink()
+	if err != nil {
+		return err
+	}
+	defer connB.Close()
+
+	var m netlinkMsg
+	netlinkHeader(&m, &unix.IfInfomsg{Family: unix.AF_UNSPEC})
+	vethLinkAttrs(&m, nameA, fdA, opts.MTU, opts.HardwareAddrA)
+	m.nest(unix.IFLA_LINKINFO, func() {
+		m.attrString(unix.IFLA_INFO_KIND, "veth")
+		m.nest(unix.IFLA_INFO_DATA, func() {
+			m.nest(vethInfoPeer, func() {
+				netlinkHeader(&m, &unix.IfInfomsg{Family: unix.AF_UNSPEC})
+				vethLinkAttrs(&m, nameB, fdB, opts.MTU, opts.HardwareAddrB)
+			})
+		})
+	})
+
+	if _, err := connA.execute(unix.RTM_NEWLINK, unix.NLM_F_CREATE|unix.NLM_F_EXCL, m.b); err != nil {
+		return fmt.Errorf("error creating veth pair %s/%s: %w", nameA, nameB, err)
+	}
+	defer func() {
+		if retErr != nil {
+			// Deleting one end of the pair deletes the other end as well.
+			connA.deleteLink(nameA)
+		}
+	}()
+
+	if !opts.Up {
+		return nil
+	}
+	if err := connA.setLinkFlags(nameA, unix.IFF_UP, unix.IFF_UP); err != nil {
+		return fmt.Errorf("error setting link %s up: %w", nameA, err)
+	}
+	if err := connB.setLinkFlags(nameB, unix.IFF_UP, unix.IFF_UP); err != nil {
+		return fmt.Errorf("error setting link %s up: %w", nameB, err)
+	}
+	return nil
+}
+
+func vethLinkAttrs(m *netlinkMsg, name string, nsFd, mtu int, addr net.HardwareAddr) {
+	m.attrString(unix.IFLA_IFNAME, name)
+	m.attrU32(unix.IFLA_NET_NS_FD, uint32(nsFd))
+	if mtu > 0 {
+		m.attrU32(unix.IFLA_MTU, uint32(mtu))
+	}
+	if len(addr) > 0 {
+		m.attr(unix.IFLA_ADDRESS, addr)
+	}
+}
@@ -0,0 +1,87 @@
+package gonso
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestVeth(t *testing.T) {
+	for name, flags := range map[string]int{"netns": NS_NET, "userns": NS_NET | NS_USER} {
+		flags := flags
+		t.Run(name, func(t *testing.T) {
+			sets, err := UnshareN(flags, 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			a, b := sets[0], sets[1]
+			defer a.Close()
+			defer b.Close()
+
+			macA := net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x0a}
+			macB := net.HardwareAddr{0x02, 0x00, 0x00, 0x00, 0x00, 0x0b}
+			opts := VethOpts{MTU: 1400, HardwareAddrA: macA, HardwareAddrB: macB, Up: true}
+			if err := Veth(a, b, "veth-a", "veth-b", opts); err != nil {
+				t.Fatal(err)
+			}
+
+			for _, tc := range []struct {
+				s    Set
+				name string
+				mac  net.HardwareAddr
+			}{
+				{a, "veth-a", macA},
+				{b, "veth-b", macB},
+			} {
+				iface := linkByName(t, tc.s, tc.name)
+				if iface.Flags&net.FlagUp == 0 {
+					t.Errorf("%s: expected link to be up", tc.name)
+				}
+				if iface.MTU != 1400 {
+					t.Errorf("%s: expected mtu 1400, got %d", tc.name, iface.MTU)
+				}
+				if !bytes.Equal(iface.HardwareAddr, tc.mac) {
+					t.Errorf("%s: expected mac %s, got %s", tc.name, tc.mac, iface.HardwareAddr)
+				}
+			}
+
+			// The peer name is taken in b so nothing should be created in a.
+			if err := Veth(a, b, "veth-c", "veth-b", VethOpts{}); !errors.Is(err, unix.EEXIST) {
+				t.Errorf("expected EEXIST, got: %v", err)
+			}
+			netns, err := a.Dup(NS_NET)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer netns.Close()
+			var lookupErr error
+			if err := netns.Do(func() { _, lookupErr = net.InterfaceByName("veth-c") }); err != nil {
+				t.Fatal(err)
+			}
+			if lookupErr == nil {
+				t.Error("expected veth-c to not exist")
+			}
+		})
+	}
+
+	t.Run("same set", func(t *testing.T) {
+		set, err := Unshare(NS_NET)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer set.Close()
+
+		if err := Veth(set, set, "veth0", "veth1", VethOpts{}); err != nil {
+			t.Fatal(err)
+		}
+		// Without Up the ends are left down.
+		for _, name := range []string{"veth0", "veth1"} {
+			if iface := linkByName(t, set, name); iface.Flags&net.FlagUp != 0 {
+				t.Errorf("%s: expected link to be down", name)
+			}
+		}
+	})
+}